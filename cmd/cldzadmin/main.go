@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cloudzz-dev/cldzmsg/internal/server/auth"
+	"github.com/cloudzz-dev/cldzmsg/internal/server/config"
+	"github.com/cloudzz-dev/cldzmsg/internal/server/models"
+	"github.com/cloudzz-dev/cldzmsg/internal/server/storage"
+)
+
+const usage = `cldzadmin - offline maintenance for a cldzmsg server
+
+Usage:
+  cldzadmin [-config file] <command> [arguments]
+
+Commands:
+  create-user <username> [-password-stdin]   Create an account (prints a generated password by default)
+  reset-password <username> [-password-stdin] Set a new password
+  ban <username>                             Disable an account
+  unban <username>                           Re-enable an account
+  export <conversation-id> [-format json|text] [-o file]
+                                             Export a conversation with all messages
+  migrate                                    Apply pending database migrations
+  stats                                      Print usage statistics
+
+The database and password hashing settings are read from the same config
+file and environment variables as the server (DATABASE_URL, ...).
+`
+
+func main() {
+	configPath := flag.String("config", os.Getenv("CLDZMSG_CONFIG"), "Path to YAML config file")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fail(err)
+	}
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	switch cmd {
+	case "create-user":
+		err = createUser(cfg, args)
+	case "reset-password":
+		err = resetPassword(cfg, args)
+	case "ban":
+		err = setBanned(cfg, args, true)
+	case "unban":
+		err = setBanned(cfg, args, false)
+	case "export":
+		err = export(cfg, args)
+	case "migrate":
+		err = migrate(cfg)
+	case "stats":
+		err = stats(cfg)
+	case "help", "-h", "--help":
+		flag.Usage()
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", cmd)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	os.Exit(1)
+}
+
+func openStore(cfg *config.Config) *storage.Store {
+	return storage.New(cfg.DatabaseURL)
+}
+
+// parseArgs splits "<positional> [flags]" so flags may follow the username,
+// which reads more naturally in scripts.
+func parseArgs(fs *flag.FlagSet, args []string, positional string) (string, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "", fmt.Errorf("%s: missing <%s>", fs.Name(), positional)
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return "", err
+	}
+	return args[0], nil
+}
+
+// readPassword takes the first line of stdin, or generates a password.
+func readPassword(fromStdin bool) (string, bool, error) {
+	if fromStdin {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", false, err
+		}
+		pw := strings.TrimRight(line, "\r\n")
+		if pw == "" {
+			return "", false, errors.New("empty password on stdin")
+		}
+		return pw, false, nil
+	}
+
+	buf := make([]byte, 15)
+	if _, err := rand.Read(buf); err != nil {
+		return "", false, err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), true, nil
+}
+
+func createUser(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("create-user", flag.ExitOnError)
+	stdin := fs.Bool("password-stdin", false, "Read the password from stdin")
+	username, err := parseArgs(fs, args, "username")
+	if err != nil {
+		return err
+	}
+
+	password, generated, err := readPassword(*stdin)
+	if err != nil {
+		return err
+	}
+	hash, err := auth.NewPasswordHasherFromConfig(cfg.Argon2).Hash(password)
+	if err != nil {
+		return err
+	}
+
+	store := openStore(cfg)
+	defer store.Close()
+	id, err := store.CreateUser(username, hash)
+	if err != nil {
+		return fmt.Errorf("create user %s: %w", username, err)
+	}
+
+	fmt.Printf("Created user %s (id %d)\n", username, id)
+	if generated {
+		fmt.Printf("Password: %s\n", password)
+	}
+	return nil
+}
+
+func resetPassword(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("reset-password", flag.ExitOnError)
+	stdin := fs.Bool("password-stdin", false, "Read the password from stdin")
+	username, err := parseArgs(fs, args, "username")
+	if err != nil {
+		return err
+	}
+
+	store := openStore(cfg)
+	defer store.Close()
+	user, err := lookupUser(store, username)
+	if err != nil {
+		return err
+	}
+
+	password, generated, err := readPassword(*stdin)
+	if err != nil {
+		return err
+	}
+	hash, err := auth.NewPasswordHasherFromConfig(cfg.Argon2).Hash(password)
+	if err != nil {
+		return err
+	}
+	if err := store.UpdatePasswordHash(user.ID, hash); err != nil {
+		return err
+	}
+
+	fmt.Printf("Password reset for %s\n", username)
+	if generated {
+		fmt.Printf("Password: %s\n", password)
+	}
+	fmt.Println("Existing sessions stay connected until they reconnect; use the admin API to log them out.")
+	return nil
+}
+
+func setBanned(cfg *config.Config, args []string, banned bool) error {
+	if len(args) != 1 {
+		return errors.New("expected exactly one <username>")
+	}
+	store := openStore(cfg)
+	defer store.Close()
+
+	user, err := lookupUser(store, args[0])
+	if err != nil {
+		return err
+	}
+	if err := store.SetUserDisabled(user.ID, banned); err != nil {
+		return err
+	}
+	if banned {
+		fmt.Printf("Disabled %s (id %d)\n", user.Username, user.ID)
+		fmt.Println("Existing sessions stay connected until they reconnect; use the admin API to log them out.")
+	} else {
+		fmt.Printf("Enabled %s (id %d)\n", user.Username, user.ID)
+	}
+	return nil
+}
+
+func lookupUser(store *storage.Store, username string) (*models.User, error) {
+	user, err := store.GetUserByUsername(username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("user %s not found", username)
+	}
+	return user, err
+}
+
+func export(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "json", "Output format: json or text")
+	out := fs.String("o", "", "Write to file instead of stdout")
+	idArg, err := parseArgs(fs, args, "conversation-id")
+	if err != nil {
+		return err
+	}
+	convID, err := strconv.Atoi(idArg)
+	if err != nil {
+		return fmt.Errorf("invalid conversation id %q", idArg)
+	}
+	if *format != "json" && *format != "text" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	store := openStore(cfg)
+	defer store.Close()
+
+	conv, count, err := store.GetConversation(convID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("conversation %d not found", convID)
+	}
+	if err != nil {
+		return err
+	}
+	participants, err := store.GetParticipants(convID)
+	if err != nil {
+		return err
+	}
+	messages, err := store.GetConversationMessages(convID, count)
+	if err != nil {
+		return err
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.OpenFile(*out, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(map[string]interface{}{
+			"conversation": conv,
+			"participants": participants,
+			"messages":     messages,
+			"exported_at":  time.Now().UTC(),
+		})
+	}
+
+	name := fmt.Sprintf("Conversation #%d", conv.ID)
+	if conv.Name != nil {
+		name = *conv.Name
+	}
+	names := make([]string, 0, len(participants))
+	for _, p := range participants {
+		names = append(names, p.Username)
+	}
+	fmt.Fprintf(w, "# %s\n# Participants: %s\n# Messages: %d\n\n", name, strings.Join(names, ", "), len(messages))
+	for _, m := range messages {
+		sender := m.SenderUsername
+		if sender == "" {
+			sender = "[deleted user]"
+		}
+		if m.Kind != models.MessageText {
+			fmt.Fprintf(w, "[%s] * %s\n", m.CreatedAt.Format("2006-01-02 15:04:05"), m.Content)
+			continue
+		}
+		fmt.Fprintf(w, "[%s] %s: %s\n", m.CreatedAt.Format("2006-01-02 15:04:05"), sender, m.Content)
+	}
+	return nil
+}
+
+func migrate(cfg *config.Config) error {
+	store := openStore(cfg)
+	defer store.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	applied, err := store.Migrate(ctx)
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Println("Database is up to date")
+		return nil
+	}
+	for _, v := range applied {
+		fmt.Println("Applied", v)
+	}
+	return nil
+}
+
+func stats(cfg *config.Config) error {
+	store := openStore(cfg)
+	defer store.Close()
+
+	st, err := store.Stats()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Users:                 %d (%d disabled, %d with 2FA)\n", st.Users, st.DisabledUsers, st.TOTPUsers)
+	fmt.Printf("Conversations:         %d (%d groups)\n", st.Conversations, st.Groups)
+	fmt.Printf("Messages:              %d (%d in the last 24h)\n", st.Messages, st.MessagesLast24h)
+	fmt.Printf("Active senders (7d):   %d\n", st.ActiveSendersLast7)
+	return nil
+}
@@ -1,25 +1,48 @@
 package main
 
 import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"hash"
+	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/cloudzz-dev/cldzmsg/internal/client/cache"
 	"github.com/cloudzz-dev/cldzmsg/internal/client/debug"
+	"github.com/cloudzz-dev/cldzmsg/internal/client/notify"
 	"github.com/cloudzz-dev/cldzmsg/internal/client/session"
+	"github.com/cloudzz-dev/cldzmsg/internal/client/ui/markdown"
+	"github.com/cloudzz-dev/cldzmsg/internal/client/ui/qrcode"
 	"github.com/cloudzz-dev/cldzmsg/internal/client/ui/styles"
+	"github.com/cloudzz-dev/cldzmsg/internal/client/wsconn"
 	"github.com/gorilla/websocket"
 )
 
 var profileName = "default"
 
+// insecureTLS skips certificate verification for self-signed dev servers
+var insecureTLS = false
+
 // --- View State ---
 
 type pane int
@@ -37,8 +60,26 @@ type Message struct {
 	ConversationID int       `json:"conversation_id"`
 	SenderID       int       `json:"sender_id"`
 	SenderUsername string    `json:"sender_username"`
+	Kind           string    `json:"kind"` // "text" or a system event like "member_added"
 	Content        string    `json:"content"`
 	CreatedAt      time.Time `json:"created_at"`
+	ClientID       string    `json:"client_id,omitempty"`    // Matches our pending copy to the server's
+	DeliveredTo    []int     `json:"delivered_to,omitempty"` // Recipients whose devices have it
+	Mentions       []int     `json:"mentions,omitempty"`     // Participants @mentioned
+
+	// Shared file; Content is then its caption
+	Attachment *Attachment `json:"attachment,omitempty"`
+
+	failed bool // Pending message the server rejected
+}
+
+// Attachment is a file shared in a message, downloaded with /save.
+type Attachment struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
 }
 
 type Conversation struct {
@@ -48,6 +89,63 @@ type Conversation struct {
 	CreatedAt   time.Time `json:"created_at"`
 	UnreadCount int       `json:"unread_count"`
 	LastMessage *Message  `json:"last_message,omitempty"` // For sidebar preview
+
+	// This user's own settings
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+	Archived   bool       `json:"archived"`
+	Pinned     bool       `json:"pinned"`
+	SortOrder  int        `json:"sort_order"`
+
+	mentioned bool // Unseen @mention of us
+	// Only sent with conversation_info
+	Participants []Participant `json:"participants,omitempty"`
+	MessageCount int           `json:"message_count,omitempty"`
+}
+
+type Participant struct {
+	UserID   int       `json:"user_id"`
+	Username string    `json:"username"`
+	Role     string    `json:"role"` // "owner", "admin" or "member"
+	JoinedAt time.Time `json:"joined_at"`
+	Online   bool      `json:"online"`
+}
+
+// ReadState is how far one participant has read the open conversation.
+type ReadState struct {
+	UserID            int    `json:"user_id"`
+	Username          string `json:"username"`
+	LastReadMessageID int    `json:"last_read_message_id"`
+}
+
+// title is the conversation's name, or a placeholder if it has none.
+func (conv Conversation) title() string {
+	switch {
+	case conv.Name != nil && *conv.Name != "":
+		return *conv.Name
+	case conv.IsGroup:
+		return fmt.Sprintf("Group #%d", conv.ID)
+	}
+	return fmt.Sprintf("DM #%d", conv.ID)
+}
+
+// muted reports whether notifications for the conversation are silenced.
+func (conv Conversation) muted() bool {
+	return conv.MutedUntil != nil && conv.MutedUntil.After(time.Now())
+}
+
+// isSystem reports whether the message is a timeline event rather than
+// something a user wrote.
+func (msg Message) isSystem() bool {
+	return msg.Kind != "" && msg.Kind != "text"
+}
+
+// preview is the message's text for one-line summaries, naming the file
+// of an attachment sent without a caption.
+func (msg Message) preview() string {
+	if msg.Content == "" && msg.Attachment != nil {
+		return "📎 " + msg.Attachment.Name
+	}
+	return msg.Content
 }
 
 // --- WebSocket Messages ---
@@ -66,7 +164,7 @@ type wsError struct {
 }
 
 type wsConnected struct {
-	conn *websocket.Conn
+	conn *wsconn.Conn
 }
 
 type typingTimeoutMsg struct {
@@ -77,10 +175,12 @@ type typingTimeoutMsg struct {
 
 type model struct {
 	// Connection
-	conn           *websocket.Conn
+	conn           *wsconn.Conn
 	serverURL      string
 	connected      bool
 	isReconnecting bool // Show reconnecting banner
+	online         bool // Authenticated on the current connection, so sends go out
+	loggedOut      bool // Server ended the session; don't reconnect
 
 	// Auth
 	userID          int
@@ -97,6 +197,23 @@ type model struct {
 
 pendingPassword string           // Password to save after successful auth
 
+	// Saved login trouble
+	authRetry      string // Why the server put off auto-login; it is retried shortly
+	keptOutbox     string // Cache owner whose unsent messages outlived a refused login
+	confirmDiscard bool   // Warned that logging in as someone else loses them
+	confirmLogout  bool   // Warned that logging out loses unsent messages
+
+	// Two-factor
+	authChallenge bool // Server wants a TOTP code before finishing login
+	totpInput     textinput.Model
+	totpEnabled   bool
+	showTOTP      bool
+	totpMode      string // "", "setup", "recovery" or "disable"
+	totpQR        string
+	totpSecret    string
+	totpRecovery  []string
+	totpError     string
+
 	// Typing
 	lastTypingSent time.Time
 	typingUsers    map[int]string // userID -> username (if typing)
@@ -110,14 +227,25 @@ pendingPassword string           // Password to save after successful auth
 	conversations []Conversation
 	selectedConv  int // Index in the list
 	sidebarWidth  int
+	showArchived  bool // Expand the archived section
 
 	// Chat
 	currentConvID      int
 	currentConvName    string
 	messages           []Message
-	messageInput       textinput.Model
+	messageInput       textarea.Model // Grows up to maxComposerHeight rows
 	chatViewport       viewport.Model
-	lastReadMessageIDs map[int]int // conversationID -> last read messageID
+	lastReadMessageIDs map[int]int       // conversationID -> last read messageID
+	outbox             []Message         // Sent but not yet confirmed, oldest first
+	readStates         map[int]ReadState // userID -> read position in the open conversation
+	currentMembers     []Participant     // Of the open conversation, for @completion
+	rawMarkdown        bool              // Show messages as typed instead of formatted
+
+	// Tab completion of @mentions: candidates and how many runes before
+	// the cursor the current completion (or typed prefix) takes
+	mentionMatches []string
+	mentionIdx     int
+	mentionLen     int
 
 	// Search
 	showSearch    bool
@@ -135,9 +263,26 @@ pendingPassword string           // Password to save after successful auth
 	showHelp bool
 
 	// Info Overlay
-	showInfo  bool
-	infoInput textinput.Model
-	infoMode  string // "rename" or "add_user"
+	showInfo   bool
+	infoConvID int // Conversation shown, whatever the sidebar selects meanwhile
+	infoInput  textinput.Model
+	infoMode   string // "rename" or "add_user"
+
+	// Details panel in the info overlay
+	info           *Conversation // From get_conversation_info, nil while loading
+	selectedMember int
+	infoError      string
+
+	// Desktop notifications, see internal/client/notify
+	notifier *notify.Notifier
+
+	// File transfers, which don't survive a reconnect
+	uploads      map[string]*upload // By client ID of the pending message
+	downloads    map[int]*download  // By attachment ID
+	transferNote string             // Outcome of the last transfer, shown under the chat
+
+	// Offline copy of conversations and opened chats; nil if unavailable
+	cache *cache.Cache
 
 	// System
 	err            error
@@ -146,6 +291,12 @@ pendingPassword string           // Password to save after successful auth
 
 type wsReconnect struct{}
 
+// authRetry asks the server again to log in with the saved session.
+type authRetry struct{}
+
+// authRetryDelay keeps retries under the server's login rate limit.
+const authRetryDelay = 15 * time.Second
+
 func initialModel(serverURL string) model {
 	// Load saved session
 	savedSession := session.Load(profileName)
@@ -183,10 +334,22 @@ func initialModel(serverURL string) model {
 	passwordInput.CharLimit = 64
 	passwordInput.Width = 30
 
-	messageInput := textinput.New()
-	messageInput.Placeholder = "Type a message..."
-	messageInput.CharLimit = 1000
-	messageInput.Width = 50
+	messageInput := textarea.New()
+	messageInput.Placeholder = "Type a message... (Alt+Enter for a new line)"
+	messageInput.CharLimit = 4000
+	messageInput.MaxHeight = 0 // Pasted logs can be long; the view scrolls
+	messageInput.ShowLineNumbers = false
+	messageInput.SetPromptFunc(2, func(line int) string {
+		if line == 0 {
+			return "> "
+		}
+		return "  "
+	})
+	messageInput.FocusedStyle.CursorLine = lipgloss.NewStyle()
+	// Enter sends; terminals report Shift+Enter as Ctrl+J or not at all
+	messageInput.KeyMap.InsertNewline = key.NewBinding(key.WithKeys("alt+enter", "shift+enter", "ctrl+j"))
+	messageInput.SetWidth(50)
+	messageInput.SetHeight(1)
 
 	newConvInput := textinput.New()
 	newConvInput.Placeholder = "Enter username to add..."
@@ -204,7 +367,13 @@ func initialModel(serverURL string) model {
 	searchInput.CharLimit = 100
 	searchInput.Width = 40
 
-	return model{
+	totpInput := textinput.New()
+	totpInput.Placeholder = "123456"
+	totpInput.CharLimit = 16
+	totpInput.Width = 20
+
+	m := model{
+		notifier:           loadNotifier(),
 		serverURL:          serverURL,
 		authAction:         "login",
 		serverInput:        serverInput,
@@ -214,13 +383,684 @@ func initialModel(serverURL string) model {
 		newConvInput:       newConvInput,
 		infoInput:          infoInput,
 		searchInput:        searchInput,
+		totpInput:          totpInput,
 		chatViewport:       chatViewport,
 		focusedPane:        paneAuth, // Start at auth
 		savedSession:       savedSession,
 		sidebarWidth:       30, // Fixed sidebar width
 		typingUsers:        make(map[int]string),
 		lastReadMessageIDs: make(map[int]int),
+		readStates:         make(map[int]ReadState),
+	}
+
+	// Show the last known state straight away; auto-login refreshes it
+	if savedSession != nil {
+		m.openCache(savedSession.Username)
+		m.loadCachedState()
+	}
+	return m
+}
+
+// loadNotifier sets up notifications from the profile's settings, falling
+// back to the terminal bell if they are broken.
+func loadNotifier() *notify.Notifier {
+	cfg, err := notify.LoadConfig(session.GetConfigDir(profileName))
+	if err != nil {
+		debug.Log("Notification settings: %v", err)
+	}
+	n, err := notify.New(cfg, os.Stdout)
+	if err != nil {
+		debug.Log("Notification backend: %v", err)
+		cfg.Backend = notify.BackendBell
+		n, _ = notify.New(cfg, os.Stdout)
+	}
+	return n
+}
+
+// --- Offline cache ---
+
+// openCache opens the profile's cache for this server and account.
+func (m *model) openCache(username string) {
+	m.cache.Close()
+	c, err := cache.Open(session.GetConfigDir(profileName), m.serverURL+"|"+username)
+	if err != nil {
+		debug.Log("Cache unavailable: %v", err)
+		c = nil
+	}
+	m.cache = c
+}
+
+// dropCache deletes the cache, when the account is logged out.
+func (m *model) dropCache() {
+	m.cache.Close()
+	m.cache = nil
+	m.outbox = nil
+	m.keptOutbox = ""
+	cache.Remove(session.GetConfigDir(profileName))
+}
+
+// forgetSession drops the saved login after the server refused it, and
+// shows reason on the login screen. The cache goes too unless messages
+// are still waiting to be sent: those are kept for when the account logs
+// in again, and only discarded once the user agrees.
+func (m *model) forgetSession(reason string) {
+	username := m.username
+	if m.savedSession != nil {
+		username = m.savedSession.Username
+	}
+	session.Clear(profileName)
+	m.savedSession = nil
+	m.authError = reason
+
+	if m.outbox == nil {
+		m.loadOutbox()
+	}
+	if len(m.outbox) == 0 {
+		m.dropCache()
+		return
+	}
+	m.keptOutbox = m.serverURL + "|" + username
+	m.authError += fmt.Sprintf(" %d unsent messages are kept until %s logs in again.", len(m.outbox), username)
+}
+
+// loadCachedState enters the main view with the cached conversations.
+func (m *model) loadCachedState() {
+	userID, _ := m.cache.Meta("user_id")
+	username, _ := m.cache.Meta("username")
+	recs, err := m.cache.Conversations()
+	if err != nil || userID == "" || len(recs) == 0 {
+		return
+	}
+	for _, r := range recs {
+		var conv Conversation
+		if json.Unmarshal(r.Data, &conv) == nil {
+			m.conversations = append(m.conversations, conv)
+		}
+	}
+	m.userID, _ = strconv.Atoi(userID)
+	m.username = username
+	m.authenticated = true
+	m.focusedPane = paneSidebar
+	m.loadOutbox()
+}
+
+func (m model) saveConversations() {
+	if m.cache == nil {
+		return
+	}
+	recs := make([]cache.Record, 0, len(m.conversations))
+	for _, conv := range m.conversations {
+		data, _ := json.Marshal(conv)
+		recs = append(recs, cache.Record{ID: conv.ID, Data: data})
+	}
+	if err := m.cache.SetConversations(recs); err != nil {
+		debug.Log("Cache write failed: %v", err)
+	}
+}
+
+// cacheMessages stores confirmed messages; pending ones have no ID yet.
+func (m model) cacheMessages(convID int, msgs []Message, replace bool) {
+	if m.cache == nil {
+		return
+	}
+	var recs []cache.Record
+	for _, msg := range msgs {
+		if msg.ID == 0 {
+			continue
+		}
+		data, _ := json.Marshal(msg)
+		recs = append(recs, cache.Record{ID: msg.ID, ConversationID: convID, CreatedAt: msg.CreatedAt, Data: data})
+	}
+	if err := m.cache.PutMessages(convID, recs, replace); err != nil {
+		debug.Log("Cache write failed: %v", err)
+	}
+}
+
+func (m model) cachedMessages(convID int) []Message {
+	recs, err := m.cache.Messages(convID)
+	if err != nil {
+		debug.Log("Cache read failed: %v", err)
+	}
+	var msgs []Message
+	for _, r := range recs {
+		var msg Message
+		if json.Unmarshal(r.Data, &msg) == nil {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// fetchMessages asks for what the cache is missing of a conversation:
+// everything after its newest cached message, or the latest page.
+func (m model) fetchMessages(convID int) tea.Cmd {
+	after, err := m.cache.LastMessageID(convID)
+	if err != nil {
+		after = 0
+	}
+	return m.sendWSMessage("get_messages", map[string]int{
+		"conversation_id": convID,
+		"after_id":        after,
+	})
+}
+
+// --- Outbox ---
+//
+// Messages stay in the outbox, and in the cache so they survive a restart,
+// until the server echoes them back. Sends while offline just queue, and
+// the queue is flushed once the client is logged in again after
+// reconnecting. The server ignores a client_id it has already stored, so
+// a message whose echo was lost is not duplicated by the resend.
+
+func (m *model) loadOutbox() {
+	queued, err := m.cache.Outbox()
+	if err != nil {
+		debug.Log("Cache read failed: %v", err)
+	}
+	m.outbox = []Message{}
+	for _, o := range queued {
+		var msg Message
+		if json.Unmarshal(o.Data, &msg) == nil {
+			msg.failed = o.Failed
+			m.outbox = append(m.outbox, msg)
+		}
+	}
+}
+
+// enqueue adds a new message to the outbox and sends it if online.
+func (m *model) enqueue(msg Message) tea.Cmd {
+	m.outbox = append(m.outbox, msg)
+	data, _ := json.Marshal(msg)
+	if err := m.cache.Queue(cache.Outgoing{
+		ClientID:       msg.ClientID,
+		ConversationID: msg.ConversationID,
+		CreatedAt:      msg.CreatedAt,
+		Data:           data,
+	}); err != nil {
+		debug.Log("Cache write failed: %v", err)
+	}
+	if !m.online {
+		return nil
+	}
+	return m.sendQueued(msg)
+}
+
+func (m model) sendQueued(msg Message) tea.Cmd {
+	return m.sendWSMessage("send_message", map[string]interface{}{
+		"conversation_id": msg.ConversationID,
+		"content":         msg.Content,
+		"client_id":       msg.ClientID,
+	})
+}
+
+// flushOutbox resends everything that hasn't failed, in order.
+func (m model) flushOutbox() tea.Cmd {
+	var cmds []tea.Cmd
+	for _, msg := range m.outbox {
+		if !msg.failed {
+			cmds = append(cmds, m.sendQueued(msg))
+		}
+	}
+	return tea.Sequence(cmds...)
+}
+
+// dequeue drops a message the server has confirmed.
+func (m *model) dequeue(clientID string) {
+	if clientID == "" {
+		return
+	}
+	m.outbox = slices.DeleteFunc(m.outbox, func(msg Message) bool { return msg.ClientID == clientID })
+	m.cache.Dequeue(clientID)
+}
+
+func (m *model) setFailed(clientID string, failed bool) {
+	for i := range m.outbox {
+		if m.outbox[i].ClientID == clientID {
+			m.outbox[i].failed = failed
+			m.cache.SetFailed(clientID, failed)
+		}
+	}
+}
+
+func (m model) outboxFor(convID int) []Message {
+	var msgs []Message
+	for _, msg := range m.outbox {
+		if msg.ConversationID == convID {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// retryFailed requeues the open conversation's failed messages.
+func (m *model) retryFailed() tea.Cmd {
+	var cmds []tea.Cmd
+	for i := range m.messages {
+		msg := &m.messages[i]
+		if msg.ID != 0 || !msg.failed {
+			continue
+		}
+		msg.failed = false
+		m.setFailed(msg.ClientID, false)
+		if m.online {
+			cmds = append(cmds, m.sendQueued(*msg))
+		}
+	}
+	m.updateChatViewport()
+	return tea.Sequence(cmds...)
+}
+
+// discardFailed removes the open conversation's failed messages.
+func (m *model) discardFailed() {
+	m.messages = slices.DeleteFunc(m.messages, func(msg Message) bool {
+		if msg.ID == 0 && msg.failed {
+			m.dequeue(msg.ClientID)
+			return true
+		}
+		return false
+	})
+	m.updateChatViewport()
+}
+
+// --- Attachments ---
+//
+// Files go to the server in chunks over the WebSocket: upload_start
+// reserves space, then each upload_chunk is sent once the server has
+// acknowledged the one before. Downloads ask for one chunk at a time the
+// same way.
+
+// upload is a file being sent, read from disk a chunk at a time.
+type upload struct {
+	path  string
+	id    string // The server's ID for it, once accepted
+	size  int64
+	sent  int64
+	chunk int
+}
+
+// download is an attachment being saved, written to path+".part" until
+// it is complete and its checksum matches.
+type download struct {
+	name     string
+	path     string
+	file     *os.File
+	hash     hash.Hash
+	sha256   string
+	size     int64
+	received int64
+}
+
+// runCommand handles a /command typed in the composer, reporting false
+// for anything else, which is sent as a message.
+func (m *model) runCommand(line string) (tea.Cmd, bool) {
+	line = strings.TrimSpace(line)
+	name, args := line, ""
+	if i := strings.IndexFunc(line, unicode.IsSpace); i >= 0 {
+		name, args = line[:i], strings.TrimSpace(line[i:])
+	}
+	switch name {
+	case "/attach":
+		return m.startUpload(args), true
+	case "/save":
+		return m.startDownload(args), true
+	}
+	return nil, false
+}
+
+// startUpload shares a file in the open conversation, for
+// "/attach <path> [caption]". Paths with spaces can be quoted.
+func (m *model) startUpload(args string) tea.Cmd {
+	path, caption := splitPathArg(args)
+	if path == "" {
+		m.transferNote = styles.ErrorStyle.Render("Usage: /attach <path> [caption]")
+		return nil
+	}
+	if !m.online {
+		m.transferNote = styles.ErrorStyle.Render("✗ Files can't be sent while offline")
+		return nil
+	}
+	path = expandHome(path)
+	info, err := os.Stat(path)
+	switch {
+	case err != nil:
+		m.transferNote = styles.ErrorStyle.Render("✗ " + err.Error())
+		return nil
+	case !info.Mode().IsRegular():
+		m.transferNote = styles.ErrorStyle.Render("✗ " + path + " is not a file")
+		return nil
+	case info.Size() == 0:
+		m.transferNote = styles.ErrorStyle.Render("✗ " + path + " is empty")
+		return nil
+	}
+
+	clientID := strconv.FormatInt(time.Now().UnixNano(), 36)
+	if m.uploads == nil {
+		m.uploads = make(map[string]*upload)
+	}
+	m.uploads[clientID] = &upload{path: path, size: info.Size()}
+	m.messages = append(m.messages, Message{
+		ConversationID: m.currentConvID,
+		SenderID:       m.userID,
+		SenderUsername: m.username,
+		Kind:           "text",
+		Content:        caption,
+		CreatedAt:      time.Now(),
+		ClientID:       clientID,
+		Attachment:     &Attachment{Name: filepath.Base(path), Size: info.Size()},
+	})
+	m.transferNote = ""
+	m.updateChatViewport()
+	return m.sendWSMessage("upload_start", map[string]interface{}{
+		"conversation_id": m.currentConvID,
+		"name":            filepath.Base(path),
+		"size":            info.Size(),
+		"caption":         caption,
+		"client_id":       clientID,
+	})
+}
+
+// uploadReadFailed reports that the next chunk of an upload couldn't be
+// read from disk.
+type uploadReadFailed struct {
+	clientID string
+	err      error
+}
+
+// chunkWritten reports that a chunk of a download is on disk.
+type chunkWritten struct {
+	id  int
+	n   int64
+	err error
+}
+
+// sendChunk reads and sends the part of an upload the server is waiting
+// for, off the UI goroutine.
+func (m *model) sendChunk(clientID string) tea.Cmd {
+	up := m.uploads[clientID]
+	if up == nil {
+		return nil
+	}
+	path, id, offset := up.path, up.id, up.sent
+	buf := make([]byte, min(int64(up.chunk), up.size-up.sent))
+	send := m.sendWSMessage
+	return func() tea.Msg {
+		f, err := os.Open(path)
+		if err == nil {
+			_, err = f.ReadAt(buf, offset)
+			f.Close()
+		}
+		if errors.Is(err, io.EOF) {
+			err = errors.New("the file changed while being sent")
+		}
+		if err != nil {
+			return uploadReadFailed{clientID: clientID, err: err}
+		}
+		return send("upload_chunk", map[string]interface{}{
+			"upload_id": id,
+			"offset":    offset,
+			"data":      buf,
+		})()
+	}
+}
+
+// cancelUpload gives up on an upload this side, telling the server.
+func (m *model) cancelUpload(clientID, reason string) tea.Cmd {
+	up := m.uploads[clientID]
+	if up == nil {
+		return nil
+	}
+	m.failUpload(clientID, reason)
+	return m.sendWSMessage("upload_cancel", map[string]string{"upload_id": up.id})
+}
+
+// failUpload drops an upload and its pending message.
+func (m *model) failUpload(clientID, reason string) {
+	up := m.uploads[clientID]
+	if up == nil {
+		return
+	}
+	delete(m.uploads, clientID)
+	m.messages = slices.DeleteFunc(m.messages, func(msg Message) bool {
+		return msg.ID == 0 && msg.ClientID == clientID
+	})
+	m.transferNote = styles.ErrorStyle.Render(fmt.Sprintf("✗ %s not sent: %s", filepath.Base(up.path), reason))
+	m.updateChatViewport()
+}
+
+// startDownload saves an attachment from the open conversation, for
+// "/save [id] [path]". Without an ID it takes the newest one; without a
+// path the file goes to the download directory under its own name.
+func (m *model) startDownload(args string) tea.Cmd {
+	first, rest := splitPathArg(args)
+	id, err := strconv.Atoi(first)
+	if err != nil {
+		id, rest = 0, args
+	}
+	att := m.findAttachment(id)
+	switch {
+	case att == nil && id != 0:
+		m.transferNote = styles.ErrorStyle.Render(fmt.Sprintf("✗ No attachment #%d in this conversation", id))
+		return nil
+	case att == nil:
+		m.transferNote = styles.ErrorStyle.Render("✗ No attachment to save in this conversation")
+		return nil
+	case !m.online:
+		m.transferNote = styles.ErrorStyle.Render("✗ Files can't be saved while offline")
+		return nil
+	case m.downloads[att.ID] != nil:
+		return nil
+	}
+
+	target, _ := splitPathArg(rest)
+	path := savePath(att.Name, target)
+	f, err := os.OpenFile(path+".part", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		m.transferNote = styles.ErrorStyle.Render("✗ " + err.Error())
+		return nil
+	}
+	if m.downloads == nil {
+		m.downloads = make(map[int]*download)
+	}
+	m.downloads[att.ID] = &download{
+		name:   filepath.Base(path),
+		path:   path,
+		file:   f,
+		hash:   sha256.New(),
+		sha256: att.SHA256,
+		size:   att.Size,
+	}
+	m.transferNote = ""
+	return m.sendWSMessage("download_attachment", map[string]interface{}{
+		"attachment_id": att.ID,
+		"offset":        0,
+	})
+}
+
+// findAttachment returns the open conversation's attachment with the
+// given ID, or its newest one for 0.
+func (m model) findAttachment(id int) *Attachment {
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if a := m.messages[i].Attachment; a != nil && a.ID != 0 && (id == 0 || a.ID == id) {
+			return a
+		}
+	}
+	return nil
+}
+
+// receiveChunk writes the next part of a download off the UI goroutine;
+// chunkDone carries on once it is on disk.
+// after it, or finishes the file.
+func (m *model) receiveChunk(id int, offset, size int64, data []byte) tea.Cmd {
+	d := m.downloads[id]
+	if d == nil || offset != d.received {
+		return nil
+	}
+	if len(data) == 0 {
+		m.failDownload(id, "the server sent no data")
+		return nil
+	}
+	d.size = size
+	file, hash := d.file, d.hash
+	return func() tea.Msg {
+		_, err := file.Write(data)
+		hash.Write(data)
+		return chunkWritten{id: id, n: int64(len(data)), err: err}
+	}
+}
+
+// chunkDone asks for the next part of a download, or finishes the file.
+func (m *model) chunkDone(msg chunkWritten) tea.Cmd {
+	id := msg.id
+	d := m.downloads[id]
+	if d == nil {
+		return nil // Failed meanwhile
+	}
+	if msg.err != nil {
+		m.failDownload(id, msg.err.Error())
+		return nil
+	}
+	d.received += msg.n
+	if d.received < d.size {
+		return m.sendWSMessage("download_attachment", map[string]interface{}{
+			"attachment_id": id,
+			"offset":        d.received,
+		})
+	}
+
+	if sum := hex.EncodeToString(d.hash.Sum(nil)); d.sha256 != "" && sum != d.sha256 {
+		m.failDownload(id, "checksum mismatch")
+		return nil
+	}
+	delete(m.downloads, id)
+	err := d.file.Close()
+	if err == nil {
+		err = os.Rename(d.path+".part", d.path)
+	}
+	if err != nil {
+		os.Remove(d.path + ".part")
+		m.transferNote = styles.ErrorStyle.Render(fmt.Sprintf("✗ %s not saved: %v", d.name, err))
+		return nil
 	}
+	m.transferNote = styles.MutedStyle.Render("✓ Saved " + d.path)
+	return nil
+}
+
+// failDownload drops a download and its partial file.
+func (m *model) failDownload(id int, reason string) {
+	d := m.downloads[id]
+	if d == nil {
+		return
+	}
+	delete(m.downloads, id)
+	d.file.Close()
+	os.Remove(d.path + ".part")
+	m.transferNote = styles.ErrorStyle.Render(fmt.Sprintf("✗ %s not saved: %s", d.name, reason))
+}
+
+// dropTransfers fails every transfer when the connection is lost; the
+// server forgets them too.
+func (m *model) dropTransfers() {
+	for clientID := range m.uploads {
+		m.failUpload(clientID, "connection lost")
+	}
+	for id := range m.downloads {
+		m.failDownload(id, "connection lost")
+	}
+}
+
+// transferStatus shows saves in progress, or else the last transfer's
+// outcome.
+func (m model) transferStatus() string {
+	var parts []string
+	for _, d := range m.downloads {
+		parts = append(parts, fmt.Sprintf("⇣ %s %d%%", d.name, d.received*100/max(d.size, 1)))
+	}
+	if len(parts) == 0 {
+		return m.transferNote
+	}
+	sort.Strings(parts)
+	return styles.MutedStyle.Render(strings.Join(parts, "  "))
+}
+
+// attachmentLine shows a shared file's name and size, and the ID to save
+// it by once the server has it.
+func attachmentLine(a *Attachment) string {
+	line := styles.AttachmentStyle.Render("📎 "+markdown.Sanitize(a.Name)) +
+		styles.MutedStyle.Render(" · "+formatSize(a.Size))
+	if a.ID != 0 {
+		line += styles.MutedStyle.Render(fmt.Sprintf(" · /save %d", a.ID))
+	}
+	return line
+}
+
+// splitPathArg splits the first argument, which may be quoted to contain
+// spaces, from the rest.
+func splitPathArg(args string) (first, rest string) {
+	args = strings.TrimSpace(args)
+	if q := args[:min(len(args), 1)]; q == "\"" || q == "'" {
+		if end := strings.Index(args[1:], q); end >= 0 {
+			return args[1 : end+1], strings.TrimSpace(args[end+2:])
+		}
+	}
+	if i := strings.IndexFunc(args, unicode.IsSpace); i >= 0 {
+		return args[:i], strings.TrimSpace(args[i:])
+	}
+	return args, ""
+}
+
+func expandHome(path string) string {
+	if home, err := os.UserHomeDir(); err == nil && (path == "~" || strings.HasPrefix(path, "~/")) {
+		return filepath.Join(home, path[1:])
+	}
+	return path
+}
+
+// savePath picks where to save a file called name: at target, inside
+// target if it is a directory, or in ~/Downloads (else the current
+// directory). Existing files are never overwritten; a number is added to
+// the name instead.
+func savePath(name, target string) string {
+	name = filepath.Base(strings.ReplaceAll(markdown.Sanitize(name), "\\", "/"))
+	if name == "." || name == ".." || name == "/" {
+		name = "attachment"
+	}
+
+	dir := "."
+	if home, err := os.UserHomeDir(); err == nil {
+		if info, err := os.Stat(filepath.Join(home, "Downloads")); err == nil && info.IsDir() {
+			dir = filepath.Join(home, "Downloads")
+		}
+	}
+	if target != "" {
+		target = expandHome(target)
+		if info, err := os.Stat(target); err == nil && info.IsDir() {
+			dir = target
+		} else {
+			dir, name = filepath.Split(target)
+		}
+	}
+
+	path := filepath.Join(dir, name)
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			return path
+		}
+		path = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%d B", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.1f KB", float64(n)/1024)
+	case n < 1024*1024*1024:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1024*1024))
+	}
+	return fmt.Sprintf("%.1f GB", float64(n)/(1024*1024*1024))
 }
 
 // --- Commands ---
@@ -229,7 +1069,11 @@ func connectToServer(url string) tea.Cmd {
 	return func() tea.Msg {
 		debug.Log("Dialing WebSocket: %s", url)
 
-		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		dialer := *websocket.DefaultDialer
+		if insecureTLS {
+			dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		}
+		conn, err := wsconn.Dial(&dialer, url)
 		if err != nil {
 			return wsError{err: err}
 		}
@@ -237,7 +1081,7 @@ func connectToServer(url string) tea.Cmd {
 	}
 }
 
-func listenForMessages(conn *websocket.Conn) tea.Cmd {
+func listenForMessages(conn *wsconn.Conn) tea.Cmd {
 	return func() tea.Msg {
 		_, msg, err := conn.ReadMessage()
 		if err != nil {
@@ -294,7 +1138,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, nil
 			}
 			// Allow ? in inputs
-			if m.focusedPane == paneChat || m.showNewConv || !m.authenticated {
+			if m.focusedPane == paneChat || m.showNewConv || m.showTOTP || !m.authenticated {
 				break
 			}
 			m.showHelp = !m.showHelp
@@ -313,6 +1157,21 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.showNewConv = false
 				return m, nil
 			}
+			if m.showTOTP {
+				m.closeTOTP()
+				return m, nil
+			}
+			if m.authChallenge {
+				// Abandon the half-finished login
+				m.authChallenge = false
+				m.isLoading = false
+				m.totpInput.SetValue("")
+				m.savedSession = nil // Don't auto-login straight back into the challenge
+				if m.conn != nil {
+					m.conn.Close()
+				}
+				return m, nil
+			}
 			// If in chat, focus sidebar
 			if m.authenticated && m.focusedPane == paneChat {
 				m.focusedPane = paneSidebar
@@ -321,13 +1180,43 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 		case "q":
 			// Only quit if in sidebar or auth, otherwise handled above/below
-			if m.focusedPane == paneSidebar || !m.authenticated {
+			if (m.focusedPane == paneSidebar && !m.showTOTP) || (!m.authenticated && !m.authChallenge) {
 				return m, tea.Quit
 			}
 		}
 
+		// Two-Factor Overlay Handling
+		if m.showTOTP {
+			if msg.String() == "enter" {
+				code := strings.TrimSpace(m.totpInput.Value())
+				switch m.totpMode {
+				case "recovery":
+					m.closeTOTP()
+					return m, nil
+				case "setup", "disable":
+					if code == "" {
+						return m, nil
+					}
+					m.totpInput.SetValue("")
+					m.totpError = ""
+					action := "totp_confirm"
+					if m.totpMode == "disable" {
+						action = "totp_disable"
+					}
+					return m, m.sendWSMessage(action, map[string]string{"code": code})
+				}
+			}
+			m.totpInput, _ = m.totpInput.Update(msg)
+			return m, nil
+		}
+
 		// Info Overlay Handling
 		if m.showInfo {
+			if m.infoMode == "" {
+				if cmd, handled := m.handleMemberKey(msg.String()); handled {
+					return m, cmd
+				}
+			}
 			switch msg.String() {
 			case "a":
 				if m.infoMode == "" {
@@ -347,29 +1236,27 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 			case "L":
 				if m.infoMode == "" {
-					conv := m.conversations[m.selectedConv]
 					m.showInfo = false
 					return m, m.sendWSMessage("leave_conversation", map[string]int{
-						"conversation_id": conv.ID,
+						"conversation_id": m.infoConvID,
 					})
 				}
 			case "enter":
 				if m.infoMode != "" && m.infoInput.Value() != "" {
-					conv := m.conversations[m.selectedConv]
 					val := m.infoInput.Value()
 					m.infoInput.SetValue("")
 					mode := m.infoMode
 					m.infoMode = ""
-					m.showInfo = false
+					m.infoError = ""
 
 					if mode == "add_user" {
 						return m, m.sendWSMessage("add_participant", map[string]interface{}{
-							"conversation_id": conv.ID,
+							"conversation_id": m.infoConvID,
 							"username":        val,
 						})
 					} else {
 						return m, m.sendWSMessage("rename_conversation", map[string]interface{}{
-							"conversation_id": conv.ID,
+							"conversation_id": m.infoConvID,
 							"name":            val,
 						})
 					}
@@ -379,6 +1266,19 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, nil
 		}
 
+		// Second login step: verification code
+		if m.authChallenge {
+			if msg.String() == "enter" && strings.TrimSpace(m.totpInput.Value()) != "" {
+				code := strings.TrimSpace(m.totpInput.Value())
+				m.totpInput.SetValue("")
+				m.isLoading = true
+				m.authError = ""
+				return m, m.sendWSMessage("auth_totp", map[string]string{"code": code})
+			}
+			m.totpInput, _ = m.totpInput.Update(msg)
+			return m, nil
+		}
+
 		// Auth View Handling
 		if !m.authenticated {
 			debug.Log("Key pressed: %q | Server: %q | User: %q | Pass: %q", msg.String(), m.serverInput.Value(), m.usernameInput.Value(), m.passwordInput.Value())
@@ -422,6 +1322,15 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						// Default to wss:// if no scheme provided
 						url = "wss://" + url
 					}
+
+					// Another account's cache replaces the kept one, unsent messages included
+					if m.keptOutbox != "" && m.keptOutbox != url+"|"+m.usernameInput.Value() && !m.confirmDiscard {
+						_, owner, _ := strings.Cut(m.keptOutbox, "|")
+						m.isLoading = false
+						m.confirmDiscard = true
+						m.authError = fmt.Sprintf("%d unsent messages of %s will be discarded. Press Enter again to continue.", len(m.outbox), owner)
+						return m, nil
+					}
 					m.serverURL = url
 
 					debug.Log("Attempting auth: Server=%s Action=%s User=%s", m.serverURL, m.authAction, m.usernameInput.Value())
@@ -455,7 +1364,11 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			case "ctrl+g":
 				m.newConvIsGroup = !m.newConvIsGroup
 			case "ctrl+s":
-				if len(m.newConvUsers) > 0 {
+				// A DM is one conversation per pair of users
+				if !m.newConvIsGroup && len(m.newConvUsers) > 1 {
+					return m, nil
+				}
+				if len(m.newConvUsers) > 0 {
 					var name string
 					if m.newConvIsGroup {
 						name = fmt.Sprintf("Group: %s", strings.Join(m.newConvUsers, ", "))
@@ -475,31 +1388,46 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		switch m.focusedPane {
 		case paneSidebar:
+			confirmLogout := m.confirmLogout
+			m.confirmLogout = false
 			switch msg.String() {
 			case "up", "k":
 				if m.selectedConv > 0 {
 					m.selectedConv--
 				}
 			case "down", "j":
-				if m.selectedConv < len(m.conversations)-1 {
+				if m.selectedConv < m.visibleConversations()-1 {
 					m.selectedConv++
 				}
+			case "p", "m", "a":
+				return m, m.toggleSetting(msg.String())
+			case "N":
+				m.cycleNotifyMode()
+			case "A":
+				m.showArchived = !m.showArchived
+				m.selectedConv = min(m.selectedConv, max(m.visibleConversations()-1, 0))
+			case "K", "shift+up":
+				return m, m.movePinned(-1)
+			case "J", "shift+down":
+				return m, m.movePinned(1)
 			case "r":
-				if len(m.conversations) > 0 {
-					m.showInfo = true
+				if id := m.selectedConvID(); id != 0 {
+					cmd := m.openInfo(id)
 					m.infoMode = "rename"
 					m.infoInput.Placeholder = "New name..."
 					m.infoInput.Focus()
 					m.infoInput.SetValue("")
-					return m, nil
+					return m, cmd
 				}
 			case "enter", "l", "right":
-				if len(m.conversations) > 0 {
-					conv := m.conversations[m.selectedConv]
+				if conv, ok := m.selectedConversation(); ok {
 					// If switching conversation
 					if conv.ID != m.currentConvID {
 						m.currentConvID = conv.ID
-						m.messages = nil // Clear previous messages
+						m.messages = append(m.cachedMessages(conv.ID), m.outboxFor(conv.ID)...) // Until the server catches up
+						m.readStates = make(map[int]ReadState)
+						m.currentMembers = nil
+						m.conversations[m.selectedConv].mentioned = false
 						m.updateChatViewport()
 
 						if conv.Name != nil && *conv.Name != "" {
@@ -510,20 +1438,40 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 							m.currentConvName = fmt.Sprintf("DM #%d", conv.ID)
 						}
 
-						cmds = append(cmds, m.sendWSMessage("get_messages", map[string]int{
+						cmds = append(cmds, m.fetchMessages(conv.ID), m.sendWSMessage("get_conversation_info", map[string]int{
 							"conversation_id": conv.ID,
 						}))
 					}
 					m.focusedPane = paneChat
 					m.messageInput.Focus()
 				}
+			case "i":
+				if id := m.selectedConvID(); id != 0 {
+					return m, m.openInfo(id)
+				}
 			case "n":
 				m.showNewConv = true
 				m.newConvInput.Focus()
 				m.newConvUsers = []string{}
+			case "T":
+				m.showTOTP = true
+				m.totpError = ""
+				m.totpInput.SetValue("")
+				if m.totpEnabled {
+					m.totpMode = "disable"
+					m.totpInput.Focus()
+					return m, nil
+				}
+				m.totpMode = ""
+				return m, m.sendWSMessage("totp_setup", struct{}{})
 			// Provide logout option
 			case "L":
+				if len(m.outbox) > 0 && !confirmLogout {
+					m.confirmLogout = true
+					return m, nil
+				}
 				session.Clear(profileName)
+				m.dropCache()
 				return m, tea.Quit // Or reset state to auth, but quit is safer for now
 			}
 
@@ -556,7 +1504,13 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, nil
 			}
 
+			if msg.String() != "tab" {
+				m.mentionMatches = nil
+			}
 			switch msg.String() {
+			case "tab":
+				m.completeMention()
+				return m, nil
 			case "esc": // Back to sidebar navigation
 				m.focusedPane = paneSidebar
 				m.messageInput.Blur()
@@ -566,21 +1520,42 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.searchInput.Focus()
 				m.messageInput.Blur()
 				return m, nil
-			case "i":
-				m.showInfo = true
-				m.infoMode = ""
+			case "alt+i": // A plain i has to reach the composer
+				return m, m.openInfo(m.currentConvID)
+			case "ctrl+t": // Formatted / raw text
+				m.rawMarkdown = !m.rawMarkdown
+				m.updateChatViewport()
+				return m, nil
 			case "enter":
-				if m.messageInput.Value() != "" {
+				if cmd, ok := m.runCommand(m.messageInput.Value()); ok {
+					m.messageInput.SetValue("")
+					cmds = append(cmds, cmd)
+				} else if m.messageInput.Value() != "" {
 					content := m.messageInput.Value()
 					m.messageInput.SetValue("")
-					cmds = append(cmds, m.sendWSMessage("send_message", map[string]interface{}{
-						"conversation_id": m.currentConvID,
-						"content":         content,
-					}))
+
+					// Show it straight away as "sending" until the server echoes it
+					pending := Message{
+						ConversationID: m.currentConvID,
+						SenderID:       m.userID,
+						SenderUsername: m.username,
+						Kind:           "text",
+						Content:        content,
+						CreatedAt:      time.Now(),
+						ClientID:       strconv.FormatInt(time.Now().UnixNano(), 36),
+					}
+					m.messages = append(m.messages, pending)
+					m.updateChatViewport()
+					cmds = append(cmds, m.enqueue(pending))
 				}
+			case "ctrl+r": // Retry messages that failed to send
+				cmds = append(cmds, m.retryFailed())
+			case "ctrl+x": // Give up on them
+				m.discardFailed()
 			}
 			m.messageInput, _ = m.messageInput.Update(msg)
 			m.chatViewport, _ = m.chatViewport.Update(msg)
+			m.resizeComposer()
 		}
 
 	case typingTimeoutMsg:
@@ -605,10 +1580,9 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		styles.HeaderStyle = styles.HeaderStyle.Width(chatWidth - 2)
 		styles.FooterStyle = styles.FooterStyle.Width(chatWidth - 2)
 
-		// Viewport takes remaining height: Total - Header - Footer - Borders
-		viewportHeight := chatHeight - 4 - 3 // Approximate
-		m.chatViewport = viewport.New(chatWidth-4, viewportHeight)
-		m.messageInput.Width = chatWidth - 6
+		m.chatViewport = viewport.New(chatWidth-4, 1)
+		m.messageInput.SetWidth(chatWidth - 6)
+		m.layoutChat()
 
 		m.updateChatViewport()
 
@@ -617,6 +1591,8 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		m.conn = msg.conn
 		m.connected = true
+		m.online = false // Until auth_success, which flushes the outbox
+		m.loggedOut = false
 		m.isReconnecting = false // Clear reconnecting state
 		m.reconnectCount = 0     // Reset reconnect counter on successful connection
 
@@ -649,14 +1625,21 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case wsError:
 		m.connected = false
+		m.online = false
 		m.conn = nil
+		m.dropTransfers()
+
+		if m.loggedOut {
+			return m, nil
+		}
 
 		debug.Log("WebSocket Connection Error (Count: %d): %v", m.reconnectCount, msg.err)
 
-		if m.reconnectCount < 5 {
+		// Once logged in, the cache keeps the client usable, so keep trying
+		if m.reconnectCount < 5 || m.authenticated {
 			m.reconnectCount++
 			m.isReconnecting = true
-			delay := time.Second * time.Duration(m.reconnectCount)
+			delay := time.Second * time.Duration(min(m.reconnectCount, 30))
 			return m, tea.Tick(delay, func(t time.Time) tea.Msg {
 				return wsReconnect{}
 			})
@@ -669,6 +1652,23 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.isReconnecting = true
 		return m, connectToServer(m.serverURL)
 
+	case uploadReadFailed:
+		return m, m.cancelUpload(msg.clientID, msg.err.Error())
+
+	case chunkWritten:
+		return m, m.chunkDone(msg)
+
+	case authRetry:
+		// A reconnect in the meantime will have logged in, or tried to
+		if m.conn == nil || m.online || m.savedSession == nil {
+			return m, nil
+		}
+		return m, m.sendWSMessage("auth", map[string]string{
+			"username": m.savedSession.Username,
+			"password": m.savedSession.Password,
+			"action":   "login",
+		})
+
 	case wsIncoming:
 		debug.Log("Received WS Message: %s", string(msg.data))
 
@@ -688,15 +1688,40 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			var resp struct {
 				UserID        int            `json:"user_id"`
 				Username      string         `json:"username"`
+				TOTPEnabled   bool           `json:"totp_enabled"`
 				Conversations []Conversation `json:"conversations"`
 			}
 			json.Unmarshal(msg.data, &resp)
+			m.authChallenge = false
+			m.totpEnabled = resp.TOTPEnabled
 			m.userID = resp.UserID
-		m.username = resp.Username
-		m.conversations = resp.Conversations
-		m.authenticated = true
-		m.focusedPane = paneSidebar
-		m.authError = ""
+			m.username = resp.Username
+			selected := m.selectedConvID()
+			m.conversations = resp.Conversations
+			m.sortConversations(selected)
+			if !m.authenticated {
+				m.focusedPane = paneSidebar
+			}
+			m.authenticated = true
+			m.authError = ""
+			m.authRetry = ""
+			m.keptOutbox = ""
+			m.confirmDiscard = false
+
+			// Refresh the cache, and catch up on the open chat after a reconnect
+			m.openCache(resp.Username)
+			m.cache.SetMeta("user_id", strconv.Itoa(resp.UserID))
+			m.cache.SetMeta("username", resp.Username)
+			m.saveConversations()
+			if m.currentConvID != 0 {
+				cmds = append(cmds, m.fetchMessages(m.currentConvID))
+			}
+
+			// Send what was written while offline. The cache may have just
+			// been emptied for another account, so reload the queue from it.
+			m.online = true
+			m.loadOutbox()
+			cmds = append(cmds, m.flushOutbox())
 
 			// Save session for future auto-login
 			if m.pendingPassword != "" {
@@ -704,18 +1729,88 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.pendingPassword = ""
 			}
 
-		case "auth_error":
+		case "auth_challenge":
 			m.isLoading = false
 			var resp struct {
 				Error string `json:"error"`
 			}
 			json.Unmarshal(msg.data, &resp)
+			m.authChallenge = true
 			m.authError = resp.Error
+			m.totpInput.Placeholder = "Code or recovery code"
+			m.totpInput.Focus()
 
-			// Clear saved session if auto-login failed
-			if m.savedSession != nil {
-				session.Clear(profileName)
-				m.savedSession = nil
+		case "totp_setup":
+			var resp struct {
+				Secret string `json:"secret"`
+				URI    string `json:"uri"`
+			}
+			json.Unmarshal(msg.data, &resp)
+			qr, err := qrcode.Render(resp.URI)
+			if err != nil {
+				debug.Log("QR render error: %v", err)
+			}
+			m.totpQR = qr
+			m.totpSecret = resp.Secret
+			m.totpMode = "setup"
+			m.totpInput.Placeholder = "123456"
+			m.totpInput.Focus()
+
+		case "totp_enabled":
+			var resp struct {
+				RecoveryCodes []string `json:"recovery_codes"`
+			}
+			json.Unmarshal(msg.data, &resp)
+			m.totpEnabled = true
+			m.totpRecovery = resp.RecoveryCodes
+			m.totpMode = "recovery"
+			m.totpQR = ""
+			m.totpSecret = ""
+			m.totpInput.Blur()
+
+		case "totp_disabled":
+			m.totpEnabled = false
+			m.closeTOTP()
+
+		case "totp_error":
+			var resp struct {
+				Error string `json:"error"`
+			}
+			json.Unmarshal(msg.data, &resp)
+			m.totpError = resp.Error
+
+		case "auth_error":
+			m.isLoading = false
+			var resp struct {
+				Error string `json:"error"`
+				Code  string `json:"code"`
+			}
+			json.Unmarshal(msg.data, &resp)
+
+			// Rate limits and server trouble say nothing about the saved
+			// password: stay in the cached view and ask again later
+			refused := resp.Code == "invalid_credentials" || resp.Code == "account_disabled"
+			if m.savedSession != nil && !refused && m.authenticated {
+				m.authRetry = resp.Error
+				cmds = append(cmds, tea.Tick(authRetryDelay, func(time.Time) tea.Msg {
+					return authRetry{}
+				}))
+				break
+			}
+
+			if m.savedSession != nil && refused {
+				m.forgetSession(resp.Error)
+			} else {
+				m.authError = resp.Error
+			}
+			m.authRetry = ""
+			// Leave the cached view for the login screen
+			if m.authenticated {
+				m.authenticated = false
+				m.focusedPane = paneAuth
+				m.conversations = nil
+				m.messages = nil
+				m.currentConvID = 0
 			}
 
 		case "conversations":
@@ -724,22 +1819,133 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 			json.Unmarshal(msg.data, &resp)
 			m.conversations = resp.Conversations
+			m.saveConversations()
+			for _, conv := range m.conversations {
+				if conv.ID == m.currentConvID && conv.Name != nil && *conv.Name != "" {
+					m.currentConvName = *conv.Name
+				}
+			}
+			if m.selectedConv >= len(m.conversations) {
+				m.selectedConv = max(len(m.conversations)-1, 0)
+			}
 
-		case "conversation_created":
+		case "conversation_info":
 			var resp struct {
 				Conversation Conversation `json:"conversation"`
 			}
 			json.Unmarshal(msg.data, &resp)
-			m.conversations = append([]Conversation{resp.Conversation}, m.conversations...)
+			if resp.Conversation.ID == m.currentConvID {
+				m.currentMembers = resp.Conversation.Participants
+			}
+			if m.showInfo && m.infoConvID == resp.Conversation.ID {
+				m.info = &resp.Conversation
+				m.infoError = ""
+				if m.selectedMember >= len(m.info.Participants) {
+					m.selectedMember = len(m.info.Participants) - 1
+				}
+				if m.selectedMember < 0 {
+					m.selectedMember = 0
+				}
+			}
+
+		case "error":
+			var resp struct {
+				Error string `json:"error"`
+			}
+			json.Unmarshal(msg.data, &resp)
+			m.infoError = resp.Error
+
+		case "conversation_created", "conversation_added", "conversation_updated":
+			var resp struct {
+				Conversation Conversation `json:"conversation"`
+			}
+			json.Unmarshal(msg.data, &resp)
+			m.upsertConversation(resp.Conversation)
+			m.saveConversations()
+			if (m.showInfo && m.infoMode == "" && m.infoConvID == resp.Conversation.ID) ||
+				resp.Conversation.ID == m.currentConvID {
+				cmds = append(cmds, m.sendWSMessage("get_conversation_info", map[string]int{
+					"conversation_id": resp.Conversation.ID,
+				}))
+			}
+
+		case "conversation_removed":
+			var resp struct {
+				ConversationID int `json:"conversation_id"`
+			}
+			json.Unmarshal(msg.data, &resp)
+			m.removeConversation(resp.ConversationID)
+			m.saveConversations()
 
 		case "messages":
 			var resp struct {
-				Messages []Message `json:"messages"`
+				ConversationID int         `json:"conversation_id"`
+				AfterID        int         `json:"after_id"`
+				Complete       bool        `json:"complete"`
+				Messages       []Message   `json:"messages"`
+				ReadStates     []ReadState `json:"read_states"`
 			}
 			json.Unmarshal(msg.data, &resp)
-			m.messages = resp.Messages
+			if resp.ConversationID != m.currentConvID {
+				break // Answer for a conversation we've since left
+			}
+
+			// A full page or a gap replaces the cache, otherwise it's merged
+			m.cacheMessages(resp.ConversationID, resp.Messages, resp.AfterID == 0 || !resp.Complete)
+			pending := m.outboxFor(resp.ConversationID)
+			if m.cache != nil {
+				m.messages = append(m.cachedMessages(resp.ConversationID), pending...)
+			} else {
+				m.messages = append(resp.Messages, pending...)
+			}
+			m.readStates = make(map[int]ReadState)
+			for _, st := range resp.ReadStates {
+				m.readStates[st.UserID] = st
+			}
 			m.updateChatViewport()
 
+		case "read_receipt":
+			var resp struct {
+				ConversationID int    `json:"conversation_id"`
+				UserID         int    `json:"user_id"`
+				Username       string `json:"username"`
+				MessageID      int    `json:"message_id"`
+			}
+			json.Unmarshal(msg.data, &resp)
+			if resp.UserID == m.userID {
+				// Read on another device
+				m.lastReadMessageIDs[resp.ConversationID] = resp.MessageID
+				for i := range m.conversations {
+					if m.conversations[i].ID == resp.ConversationID {
+						m.conversations[i].UnreadCount = 0
+					}
+				}
+			}
+			if resp.ConversationID == m.currentConvID {
+				m.readStates[resp.UserID] = ReadState{
+					UserID:            resp.UserID,
+					Username:          resp.Username,
+					LastReadMessageID: resp.MessageID,
+				}
+				m.updateChatViewport()
+			}
+
+		case "mention":
+			var resp struct {
+				ConversationID int     `json:"conversation_id"`
+				Message        Message `json:"message"`
+			}
+			json.Unmarshal(msg.data, &resp)
+			// Alert even in muted conversations
+			if resp.ConversationID != m.currentConvID {
+				for i := range m.conversations {
+					if m.conversations[i].ID == resp.ConversationID {
+						m.conversations[i].mentioned = true
+						m.notify(m.conversations[i], resp.Message, true)
+					}
+				}
+			}
+
 		case "new_message":
 			var resp struct {
 				Message Message `json:"message"`
@@ -757,39 +1963,166 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 			if foundIdx != -1 {
 				conv := m.conversations[foundIdx]
-				if resp.Message.ConversationID != m.currentConvID {
+				if resp.Message.ConversationID != m.currentConvID && resp.Message.SenderID != m.userID {
 					conv.UnreadCount++
-					// Play terminal bell for messages in other conversations
-					if resp.Message.SenderID != m.userID {
-						fmt.Print("\a") // Terminal bell
+					// Notify for messages in other conversations
+					// (mentions notify from the mention event instead)
+					if resp.Message.SenderID != m.userID && !resp.Message.isSystem() &&
+						!slices.Contains(resp.Message.Mentions, m.userID) {
+						m.notify(conv, resp.Message, false)
 					}
 				}
 				conv.LastMessage = &resp.Message
 
-				// Remove and prepend
+				// Move to the top of its section
+				selected := m.selectedConvID()
 				m.conversations = append(m.conversations[:foundIdx], m.conversations[foundIdx+1:]...)
 				m.conversations = append([]Conversation{conv}, m.conversations...)
+				m.sortConversations(selected)
+				m.saveConversations()
+			}
 
-				// Adjust selected index
-			if m.selectedConv == foundIdx {
-				m.selectedConv = 0
-			} else if foundIdx > m.selectedConv {
-				// Conv below moved to top, our index shifts down
-				m.selectedConv++
+			// Tell the sender it reached this device
+			if resp.Message.SenderID != m.userID && !resp.Message.isSystem() {
+				cmds = append(cmds, m.sendWSMessage("message_ack", map[string][]int{
+					"message_ids": {resp.Message.ID},
+				}))
 			}
+
+			if resp.Message.SenderID == m.userID {
+				m.dequeue(resp.Message.ClientID)
+				delete(m.uploads, resp.Message.ClientID)
 			}
 
 			if resp.Message.ConversationID == m.currentConvID {
-				m.messages = append(m.messages, resp.Message)
+				if i := m.pendingIndex(resp.Message.ClientID); i != -1 && resp.Message.SenderID == m.userID {
+					m.messages[i] = resp.Message
+				} else {
+					m.messages = append(m.messages, resp.Message)
+				}
+				// Only the open chat: others may have gaps the next fetch fills
+				m.cacheMessages(m.currentConvID, []Message{resp.Message}, false)
 				m.updateChatViewport()
 				// Send read receipt if active
 				cmds = append(cmds, m.sendWSMessage("read_receipt", map[string]int{
 					"conversation_id": m.currentConvID,
+					"message_id":      resp.Message.ID,
 				}))
 				// Clear any typing indicator for this user if they just sent a message
 				delete(m.typingUsers, resp.Message.SenderID)
 			}
 
+		case "message_failed":
+			var resp struct {
+				ClientID string `json:"client_id"`
+			}
+			json.Unmarshal(msg.data, &resp)
+			m.setFailed(resp.ClientID, true)
+			if i := m.pendingIndex(resp.ClientID); i != -1 {
+				m.messages[i].failed = true
+				m.updateChatViewport()
+			}
+
+		case "upload_ready", "upload_progress":
+			var resp struct {
+				UploadID  string `json:"upload_id"`
+				ClientID  string `json:"client_id"`
+				ChunkSize int    `json:"chunk_size"`
+				Received  int64  `json:"received"`
+			}
+			json.Unmarshal(msg.data, &resp)
+			if up := m.uploads[resp.ClientID]; up != nil {
+				if wsMsg.Type == "upload_ready" {
+					up.id, up.chunk = resp.UploadID, max(resp.ChunkSize, 1)
+				}
+				up.sent = resp.Received
+				m.updateChatViewport()
+				cmds = append(cmds, m.sendChunk(resp.ClientID))
+			}
+
+		case "upload_failed":
+			var resp struct {
+				ClientID string `json:"client_id"`
+				Error    string `json:"error"`
+			}
+			json.Unmarshal(msg.data, &resp)
+			m.failUpload(resp.ClientID, resp.Error)
+
+		case "attachment_chunk":
+			var resp struct {
+				AttachmentID int    `json:"attachment_id"`
+				Offset       int64  `json:"offset"`
+				Size         int64  `json:"size"`
+				Data         []byte `json:"data"`
+			}
+			json.Unmarshal(msg.data, &resp)
+			cmds = append(cmds, m.receiveChunk(resp.AttachmentID, resp.Offset, resp.Size, resp.Data))
+
+		case "download_failed":
+			var resp struct {
+				AttachmentID int    `json:"attachment_id"`
+				Error        string `json:"error"`
+			}
+			json.Unmarshal(msg.data, &resp)
+			m.failDownload(resp.AttachmentID, resp.Error)
+
+		case "message_delivered":
+			var resp struct {
+				ConversationID int   `json:"conversation_id"`
+				UserID         int   `json:"user_id"`
+				MessageIDs     []int `json:"message_ids"`
+			}
+			json.Unmarshal(msg.data, &resp)
+			if resp.ConversationID == m.currentConvID {
+				delivered := make(map[int]bool, len(resp.MessageIDs))
+				for _, id := range resp.MessageIDs {
+					delivered[id] = true
+				}
+				var changed []Message
+				for i := range m.messages {
+					if delivered[m.messages[i].ID] && !slices.Contains(m.messages[i].DeliveredTo, resp.UserID) {
+						m.messages[i].DeliveredTo = append(m.messages[i].DeliveredTo, resp.UserID)
+						changed = append(changed, m.messages[i])
+					}
+				}
+				m.cacheMessages(m.currentConvID, changed, false)
+				m.updateChatViewport()
+			}
+
+		case "message_deleted":
+			var resp struct {
+				MessageID      int `json:"message_id"`
+				ConversationID int `json:"conversation_id"`
+			}
+			json.Unmarshal(msg.data, &resp)
+			m.cache.DeleteMessage(resp.MessageID)
+			if resp.ConversationID == m.currentConvID {
+				for i, message := range m.messages {
+					if message.ID == resp.MessageID {
+						m.messages = append(m.messages[:i], m.messages[i+1:]...)
+						break
+					}
+				}
+				m.updateChatViewport()
+			}
+
+		case "force_logout":
+			var resp struct {
+				Reason string `json:"reason"`
+			}
+			json.Unmarshal(msg.data, &resp)
+			// Back to the login screen without auto-login
+			m.forgetSession(resp.Reason)
+			m.authenticated = false
+			m.authChallenge = false
+			m.isLoading = false
+			m.focusedPane = paneAuth
+			m.passwordInput.SetValue("")
+			m.conversations = nil
+			m.messages = nil
+			m.currentConvID = 0
+			m.loggedOut = true
+
 		case "typing":
 			var resp struct {
 				ConversationID int    `json:"conversation_id"`
@@ -824,6 +2157,399 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, tea.Batch(cmds...)
 }
 
+func (m *model) closeTOTP() {
+	m.showTOTP = false
+	m.totpMode = ""
+	m.totpQR = ""
+	m.totpSecret = ""
+	m.totpRecovery = nil
+	m.totpError = ""
+	m.totpInput.SetValue("")
+	m.totpInput.Blur()
+}
+
+// notify raises a notification for msg, subject to the user's filters.
+func (m model) notify(conv Conversation, msg Message, mention bool) {
+	err := m.notifier.Notify(notify.Notification{
+		ConversationID: conv.ID,
+		Conversation:   conv.title(),
+		Sender:         msg.SenderUsername,
+		Body:           msg.preview(),
+		Mention:        mention,
+		Muted:          conv.muted(),
+		Time:           time.Now(),
+	})
+	if err != nil {
+		debug.Log("Notification failed: %v", err)
+	}
+}
+
+// cycleNotifyMode steps the selected conversation's notifications through
+// all, mentions only and off, and saves the choice.
+func (m model) cycleNotifyMode() {
+	conv, ok := m.selectedConversation()
+	if !ok {
+		return
+	}
+	cfg := &m.notifier.Config
+	next := map[string]string{
+		notify.ModeAll:      notify.ModeMentions,
+		notify.ModeMentions: notify.ModeOff,
+		notify.ModeOff:      notify.ModeAll,
+	}[cfg.Mode(conv.ID)]
+	if cfg.Conversations == nil {
+		cfg.Conversations = make(map[int]string)
+	}
+	cfg.Conversations[conv.ID] = next
+	if err := cfg.Save(session.GetConfigDir(profileName)); err != nil {
+		debug.Log("Saving notification settings: %v", err)
+	}
+}
+
+// upsertConversation applies a conversation pushed by the server: known
+// conversations are updated in place, new ones go to the top of their
+// section.
+func (m *model) upsertConversation(conv Conversation) {
+	selected := m.selectedConvID()
+	defer m.sortConversations(selected)
+
+	for i, existing := range m.conversations {
+		if existing.ID == conv.ID {
+			if conv.LastMessage == nil {
+				conv.LastMessage = existing.LastMessage
+			}
+			conv.mentioned = existing.mentioned
+			m.conversations[i] = conv
+			if conv.ID == m.currentConvID && conv.Name != nil && *conv.Name != "" {
+				m.currentConvName = *conv.Name
+			}
+			return
+		}
+	}
+	m.conversations = append([]Conversation{conv}, m.conversations...)
+}
+
+func (m model) selectedConvID() int {
+	conv, _ := m.selectedConversation()
+	return conv.ID
+}
+
+// selectedConversation is the sidebar's selection; there is none while
+// the list is empty.
+func (m model) selectedConversation() (Conversation, bool) {
+	if m.selectedConv < len(m.conversations) {
+		return m.conversations[m.selectedConv], true
+	}
+	return Conversation{}, false
+}
+
+// infoConversation is the conversation the details panel shows.
+func (m model) infoConversation() (Conversation, bool) {
+	for _, conv := range m.conversations {
+		if conv.ID == m.infoConvID {
+			return conv, true
+		}
+	}
+	return Conversation{}, false
+}
+
+// sortConversations puts pinned conversations first in their chosen order
+// and archived ones last, keeping activity order otherwise, and keeps the
+// given conversation selected.
+func (m *model) sortConversations(selectedID int) {
+	section := func(c Conversation) int {
+		switch {
+		case c.Archived:
+			return 2
+		case c.Pinned:
+			return 0
+		}
+		return 1
+	}
+	sort.SliceStable(m.conversations, func(i, j int) bool {
+		a, b := m.conversations[i], m.conversations[j]
+		if section(a) != section(b) {
+			return section(a) < section(b)
+		}
+		return a.Pinned && !a.Archived && a.SortOrder < b.SortOrder
+	})
+	for i, conv := range m.conversations {
+		if conv.ID == selectedID {
+			m.selectedConv = i
+		}
+	}
+	m.selectedConv = min(m.selectedConv, max(m.visibleConversations()-1, 0))
+}
+
+// visibleConversations counts the sidebar entries that can be selected:
+// archived conversations are hidden while their section is collapsed.
+func (m model) visibleConversations() int {
+	if m.showArchived {
+		return len(m.conversations)
+	}
+	return len(m.conversations) - m.archivedCount()
+}
+
+func (m model) archivedCount() int {
+	n := 0
+	for _, conv := range m.conversations {
+		if conv.Archived {
+			n++
+		}
+	}
+	return n
+}
+
+// toggleSetting flips pin ("p"), mute ("m") or archive ("a") for the
+// selected conversation. The server answers with conversation_updated.
+func (m model) toggleSetting(key string) tea.Cmd {
+	conv, ok := m.selectedConversation()
+	if !ok {
+		return nil
+	}
+	payload := map[string]interface{}{"conversation_id": conv.ID}
+	switch key {
+	case "p":
+		payload["pinned"] = !conv.Pinned
+	case "m":
+		if conv.muted() {
+			payload["mute_minutes"] = 0
+		} else {
+			payload["mute_minutes"] = -1 // Until unmuted
+		}
+	case "a":
+		payload["archived"] = !conv.Archived
+	}
+	return m.sendWSMessage("conversation_settings", payload)
+}
+
+// movePinned swaps the selected pinned conversation with its neighbour
+// and saves the new positions of every pin.
+func (m *model) movePinned(delta int) tea.Cmd {
+	i, j := m.selectedConv, m.selectedConv+delta
+	if i >= len(m.conversations) || j < 0 || j >= len(m.conversations) {
+		return nil
+	}
+	if !m.conversations[i].Pinned || !m.conversations[j].Pinned ||
+		m.conversations[i].Archived || m.conversations[j].Archived {
+		return nil
+	}
+	m.conversations[i], m.conversations[j] = m.conversations[j], m.conversations[i]
+	m.selectedConv = j
+
+	var cmds []tea.Cmd
+	for k := range m.conversations {
+		conv := &m.conversations[k]
+		if !conv.Pinned || conv.Archived || conv.SortOrder == k+1 {
+			continue
+		}
+		conv.SortOrder = k + 1
+		cmds = append(cmds, m.sendWSMessage("conversation_settings", map[string]int{
+			"conversation_id": conv.ID,
+			"sort_order":      conv.SortOrder,
+		}))
+	}
+	return tea.Batch(cmds...)
+}
+
+// removeConversation drops a conversation the user left or was removed
+// from, closing it if it was open.
+func (m *model) removeConversation(convID int) {
+	idx := -1
+	for i, conv := range m.conversations {
+		if conv.ID == convID {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		return
+	}
+	if m.showInfo && m.infoConvID == convID {
+		m.showInfo = false
+		m.infoMode = ""
+	}
+	m.conversations = append(m.conversations[:idx], m.conversations[idx+1:]...)
+	if idx < m.selectedConv || m.selectedConv >= len(m.conversations) {
+		m.selectedConv = max(m.selectedConv-1, 0)
+	}
+
+	if convID == m.currentConvID {
+		m.currentConvID = 0
+		m.currentConvName = ""
+		m.messages = nil
+		m.typingUsers = make(map[int]string)
+		m.focusedPane = paneSidebar
+		m.messageInput.Blur()
+		m.updateChatViewport()
+	}
+}
+
+// openInfo shows the details panel and fetches the conversation's info.
+func (m *model) openInfo(convID int) tea.Cmd {
+	if convID == 0 {
+		return nil
+	}
+	m.showInfo = true
+	m.infoConvID = convID
+	m.infoMode = ""
+	m.infoError = ""
+	m.info = nil
+	m.selectedMember = 0
+	return m.sendWSMessage("get_conversation_info", map[string]int{
+		"conversation_id": convID,
+	})
+}
+
+// members is the member list shown in the details panel.
+func (m model) members() []Participant {
+	if m.info == nil {
+		return nil
+	}
+	return m.info.Participants
+}
+
+// myRole is the current user's role in the open conversation.
+func (m model) myRole() string {
+	for _, p := range m.members() {
+		if p.UserID == m.userID {
+			return p.Role
+		}
+	}
+	return ""
+}
+
+// handleMemberKey moves through the member list and applies role actions
+// to the selected member. The server enforces permissions; the keys are
+// only offered when they would be allowed.
+func (m *model) handleMemberKey(key string) (tea.Cmd, bool) {
+	switch key {
+	case "up", "k":
+		if m.selectedMember > 0 {
+			m.selectedMember--
+		}
+		return nil, true
+	case "down", "j":
+		if m.selectedMember < len(m.members())-1 {
+			m.selectedMember++
+		}
+		return nil, true
+	}
+
+	action := map[string]string{
+		"x": "remove_participant",
+		"p": "promote",
+		"d": "demote",
+		"O": "transfer_ownership",
+	}[key]
+	members := m.members()
+	if action == "" || len(members) == 0 || !m.info.IsGroup {
+		return nil, false
+	}
+	target := members[m.selectedMember]
+	if target.UserID == m.userID {
+		return nil, true
+	}
+	m.infoError = ""
+	return m.sendWSMessage(action, map[string]interface{}{
+		"conversation_id": m.infoConvID,
+		"username":        target.Username,
+	}), true
+}
+
+// detailsView renders the metadata and member list of m.info.
+func (m model) detailsView() string {
+	var s strings.Builder
+	info := m.info
+	members := info.Participants
+
+	// The sidebar entry has the display name, resolved for DMs
+	if conv, _ := m.infoConversation(); conv.Name != nil && *conv.Name != "" {
+		s.WriteString(styles.ProfileStyle.Render(*conv.Name) + "\n")
+	}
+
+	online := 0
+	for _, p := range members {
+		if p.Online {
+			online++
+		}
+	}
+	kind := "Direct message"
+	if info.IsGroup {
+		kind = "Group"
+	}
+	s.WriteString(fmt.Sprintf("%s · %d members · %d online\n", kind, len(members), online))
+	s.WriteString(styles.MutedStyle.Render(fmt.Sprintf("Created %s · %d messages",
+		info.CreatedAt.Local().Format("Jan 2, 2006"), info.MessageCount)) + "\n")
+	if role := m.myRole(); info.IsGroup && role != "" {
+		s.WriteString(styles.MutedStyle.Render("Your role: "+role) + "\n")
+	}
+	s.WriteString("\n")
+
+	s.WriteString(styles.ProfileStyle.Render("Members") + "\n")
+	for i, p := range members {
+		presence := styles.MutedStyle.Render("○")
+		if p.Online {
+			presence = styles.OwnMessageStyle.Render("●")
+		}
+		line := presence + " " + p.Username
+		if p.UserID == m.userID {
+			line += " (you)"
+		}
+		if badge := roleBadge(p.Role); badge != "" {
+			line += " " + badge
+		}
+		line += styles.MutedStyle.Render(" · joined " + p.JoinedAt.Local().Format("Jan 2, 2006"))
+		if i == m.selectedMember {
+			s.WriteString(styles.SelectedItemStyle.Render(line) + "\n")
+		} else {
+			s.WriteString(styles.UnselectedItemStyle.Render(line) + "\n")
+		}
+	}
+	s.WriteString("\n")
+	return s.String()
+}
+
+func roleBadge(role string) string {
+	switch role {
+	case "owner":
+		return styles.OwnerBadgeStyle.Render("★ owner")
+	case "admin":
+		return styles.AdminBadgeStyle.Render("admin")
+	}
+	return ""
+}
+
+// maxComposerHeight is how tall the message composer grows before it
+// scrolls instead.
+const maxComposerHeight = 8
+
+// resizeComposer fits the composer's height to its text and gives the
+// rest of the chat window to the viewport.
+func (m *model) resizeComposer() {
+	width := max(m.messageInput.Width(), 1)
+	rows := 0
+	for _, line := range strings.Split(m.messageInput.Value(), "\n") {
+		// Soft wraps at word boundaries can take a row more; the
+		// textarea scrolls if so
+		rows += max(1, (lipgloss.Width(line)+width-1)/width)
+	}
+	height := min(rows, maxComposerHeight)
+	if height == m.messageInput.Height() {
+		return
+	}
+	m.messageInput.SetHeight(height)
+	m.layoutChat()
+}
+
+// layoutChat sizes the viewport to what the header, borders and
+// composer leave of the chat window.
+func (m *model) layoutChat() {
+	chatHeight := m.height - 2
+	m.chatViewport.Height = max(chatHeight-7-(m.messageInput.Height()-1), 1)
+	m.chatViewport.GotoBottom()
+}
+
 func (m *model) updateChatViewport() {
 	m.chatViewport.SetContent(m.renderChatContent())
 	m.chatViewport.GotoBottom()
@@ -833,6 +2559,13 @@ func (m *model) renderChatContent() string {
 	var content strings.Builder
 	for _, msg := range m.messages {
 		timestamp := formatRelativeTime(msg.CreatedAt)
+		if msg.isSystem() {
+			content.WriteString(fmt.Sprintf("%s %s\n",
+				styles.MutedStyle.Render(timestamp),
+				styles.SystemMessageStyle.Render("• "+msg.Content),
+			))
+			continue
+		}
 		var style lipgloss.Style
 		if msg.SenderID == m.userID {
 			style = styles.OwnMessageStyle
@@ -840,24 +2573,181 @@ func (m *model) renderChatContent() string {
 			style = styles.OtherMessageStyle
 		}
 
-		// Wrap text based on viewport width
-		maxWidth := m.chatViewport.Width - 10 // Leave room for timestamp/username
-		if maxWidth < 10 {
-			maxWidth = 10
-		}
-
-		wrappedContent := fitString(msg.Content, maxWidth)
-
-		line := fmt.Sprintf("%s %s: %s",
+		// Wrap to the viewport and line continuation lines up under the
+		// first, after the timestamp and name
+		prefix := fmt.Sprintf("%s %s: ",
 			styles.MutedStyle.Render(timestamp),
 			style.Render(msg.SenderUsername),
-			wrappedContent,
 		)
+		indent := lipgloss.Width(prefix)
+		opts := markdown.Options{Width: max(m.chatViewport.Width-indent-1, 10)}
+		if slices.Contains(msg.Mentions, m.userID) {
+			opts.Text = m.highlightMentions
+		}
+
+		var wrapped string
+		if m.rawMarkdown {
+			body := markdown.Sanitize(msg.Content)
+			if opts.Text != nil {
+				body = opts.Text(body)
+			}
+			wrapped = fitString(body, opts.Width)
+		} else {
+			wrapped = markdown.Render(msg.Content, opts)
+		}
+		if msg.Attachment != nil {
+			file := fitString(attachmentLine(msg.Attachment), opts.Width)
+			if msg.Content != "" {
+				file += "\n" + wrapped
+			}
+			wrapped = file
+		}
+		line := prefix + strings.ReplaceAll(wrapped, "\n", "\n"+strings.Repeat(" ", indent))
+		if msg.SenderID == m.userID {
+			line += " " + m.statusMarker(msg)
+		}
 		content.WriteString(line + "\n")
 	}
+
+	// Groups get a "Seen by" line under the newest message
+	if n := len(m.messages); n > 0 && m.currentIsGroup() {
+		last := m.messages[n-1]
+		if names := m.seenBy(last); len(names) > 0 {
+			content.WriteString(styles.MutedStyle.Render("  Seen by "+strings.Join(names, ", ")) + "\n")
+		}
+	}
 	return content.String()
 }
 
+// highlightMentions marks each @mention of the current user in content.
+func (m model) highlightMentions(content string) string {
+	return mentionPattern.ReplaceAllStringFunc(content, func(match string) string {
+		start := strings.Index(match, "@")
+		core := strings.TrimRight(match[start:], ".,;:!?)'\"")
+		if !strings.EqualFold(core[1:], m.username) {
+			return match
+		}
+		return match[:start] + styles.MentionStyle.Render(core) + match[start+len(core):]
+	})
+}
+
+// mentionPattern matches a word starting with @, with the space before it.
+var mentionPattern = regexp.MustCompile(`(^|\s)@\S+`)
+
+// completeMention completes the @name before the cursor from the open
+// conversation's members. Pressing Tab again cycles through the matches.
+func (m *model) completeMention() {
+	if m.mentionMatches == nil {
+		line := []rune(strings.Split(m.messageInput.Value(), "\n")[m.messageInput.Line()])
+		info := m.messageInput.LineInfo()
+		pos := min(info.StartColumn+info.ColumnOffset, len(line))
+		start := pos
+		for start > 0 && !unicode.IsSpace(line[start-1]) {
+			start--
+		}
+		if start == pos || line[start] != '@' {
+			return
+		}
+		prefix := strings.ToLower(string(line[start+1 : pos]))
+		var matches []string
+		for _, p := range m.currentMembers {
+			if p.UserID != m.userID && strings.HasPrefix(strings.ToLower(p.Username), prefix) {
+				matches = append(matches, p.Username)
+			}
+		}
+		if len(matches) == 0 {
+			return
+		}
+		sort.Strings(matches)
+		m.mentionMatches, m.mentionIdx, m.mentionLen = matches, 0, pos-start
+	} else {
+		m.mentionIdx = (m.mentionIdx + 1) % len(m.mentionMatches)
+	}
+
+	// Edit through the textarea so its cursor stays right
+	for range m.mentionLen {
+		m.messageInput, _ = m.messageInput.Update(tea.KeyMsg{Type: tea.KeyBackspace})
+	}
+	name := "@" + m.mentionMatches[m.mentionIdx] + " "
+	m.messageInput.InsertString(name)
+	m.mentionLen = len([]rune(name))
+	m.resizeComposer()
+}
+
+// seenBy lists who has read msg, other than its sender and the current user.
+func (m model) seenBy(msg Message) []string {
+	var names []string
+	for _, st := range m.readStates {
+		if st.UserID == msg.SenderID || st.UserID == m.userID {
+			continue
+		}
+		if st.LastReadMessageID >= msg.ID {
+			names = append(names, st.Username)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pendingIndex finds our not-yet-confirmed message with this client ID.
+func (m model) pendingIndex(clientID string) int {
+	if clientID == "" {
+		return -1
+	}
+	for i, msg := range m.messages {
+		if msg.ID == 0 && msg.ClientID == clientID {
+			return i
+		}
+	}
+	return -1
+}
+
+// statusMarker shows how far one of our messages has got: sending (◷),
+// sent to the server (✓), delivered to every recipient's device (✓✓) and
+// read by all of them (green ✓✓).
+func (m model) statusMarker(msg Message) string {
+	if msg.failed {
+		return styles.ErrorStyle.Render("✗ not sent · Ctrl+R retry, Ctrl+X discard")
+	}
+	if up := m.uploads[msg.ClientID]; up != nil && msg.ID == 0 {
+		return styles.MutedStyle.Render(fmt.Sprintf("⇡ %d%%", up.sent*100/up.size))
+	}
+	if msg.ID == 0 && !m.online {
+		return styles.MutedStyle.Render("◷ queued")
+	}
+	if msg.ID == 0 {
+		return styles.MutedStyle.Render("◷")
+	}
+
+	others, delivered := 0, 0
+	for _, st := range m.readStates {
+		if st.UserID == m.userID {
+			continue
+		}
+		others++
+		// Reading implies delivery, even if the ack never arrived
+		if st.LastReadMessageID >= msg.ID || slices.Contains(msg.DeliveredTo, st.UserID) {
+			delivered++
+		}
+	}
+	switch {
+	case others > 0 && len(m.seenBy(msg)) == others:
+		return styles.OwnMessageStyle.Render("✓✓")
+	case others > 0 && delivered == others:
+		return styles.MutedStyle.Render("✓✓")
+	}
+	return styles.MutedStyle.Render("✓")
+}
+
+func (m model) currentIsGroup() bool {
+	for _, conv := range m.conversations {
+		if conv.ID == m.currentConvID {
+			return conv.IsGroup
+		}
+	}
+	return false
+}
+
 // formatRelativeTime returns a human-readable relative timestamp
 func formatRelativeTime(t time.Time) string {
 	now := time.Now()
@@ -879,14 +2769,13 @@ func formatRelativeTime(t time.Time) string {
 	}
 }
 
-// Simple word wrap helper
+// fitString word-wraps s to width, keeping its own line breaks.
 func fitString(s string, width int) string {
-	if len(s) <= width {
-		return s
+	lines := strings.Split(lipgloss.NewStyle().Width(width).Render(s), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ") // Drop the padding to width
 	}
-	// Just a basic cut for now to prevent explosion, lipgloss usually handles basic wrapping
-	// But specific wrapping logic can be added here
-	return s
+	return strings.Join(lines, "\n")
 }
 
 // --- View ---
@@ -915,12 +2804,16 @@ func (m model) View() string {
 		return m.overlayInfo()
 	}
 
+	if m.showTOTP {
+		return m.overlayTOTP()
+	}
+
 	return mainView
 }
 
 func (m model) overlayHelp() string {
 	width := 50
-	height := 16
+	height := 31
 
 	var s strings.Builder
 	s.WriteString(styles.TitleStyle.Render("Help & Controls") + "\n\n")
@@ -930,11 +2823,24 @@ func (m model) overlayHelp() string {
 	s.WriteString("  ↑/k, ↓/j  Navigate\n")
 	s.WriteString("  Enter/l   Select Chat\n")
 	s.WriteString("  n         New Chat\n")
+	s.WriteString("  i         Conversation Details\n")
+	s.WriteString("  p / m / a Pin / Mute / Archive\n")
+	s.WriteString("  J/K       Move Pinned Down/Up\n")
+	s.WriteString("  A         Show/Hide Archived\n")
+	s.WriteString("  N         Notify: All/@/Off\n")
+	s.WriteString("  T         Two-Factor Auth\n")
 	s.WriteString("  L         Logout\n\n")
 
 	s.WriteString(styles.ProfileStyle.Render("Chat") + "\n")
 	s.WriteString("  Types     Type message\n")
 	s.WriteString("  Enter     Send\n")
+	s.WriteString("  Alt+Enter New Line\n")
+	s.WriteString("  Tab       Complete @mention\n")
+	s.WriteString("  Alt+I     Conversation Details\n")
+	s.WriteString("  Ctrl+T    Raw/Formatted Text\n")
+	s.WriteString("  /attach   Send a File\n")
+	s.WriteString("  /save     Save an Attachment\n")
+	s.WriteString("  Ctrl+R/X  Retry/Discard Unsent\n")
 	s.WriteString("  Esc       Back to Sidebar\n\n")
 
 	s.WriteString(styles.ProfileStyle.Render("Global") + "\n")
@@ -960,18 +2866,35 @@ func (m model) overlayHelp() string {
 }
 
 func (m model) overlayInfo() string {
-	width := 50
-	height := 14
+	width := 56
 
 	var s strings.Builder
-	s.WriteString(styles.TitleStyle.Render("Conversation Options") + "\n\n")
+	s.WriteString(styles.TitleStyle.Render("Conversation Details") + "\n\n")
 
 	switch m.infoMode {
 	case "":
-		s.WriteString("  [r] Rename Conversation\n")
-		s.WriteString("  [a] Add User\n")
+		conv, _ := m.infoConversation()
+		if m.info == nil {
+			s.WriteString(styles.MutedStyle.Render("Loading...") + "\n\n")
+		} else {
+			s.WriteString(m.detailsView())
+		}
+		role := m.myRole()
+
+		manage := !conv.IsGroup || role == "owner" || role == "admin"
+		if manage {
+			s.WriteString("  [r] Rename Conversation\n")
+		}
+		if conv.IsGroup && (role == "owner" || role == "admin") {
+			s.WriteString("  [a] Add User\n")
+			s.WriteString("  [x] Remove Selected\n")
+		}
+		if conv.IsGroup && role == "owner" {
+			s.WriteString("  [p] Promote to Admin  [d] Demote\n")
+			s.WriteString("  [O] Transfer Ownership\n")
+		}
 		s.WriteString("  [L] Leave Conversation\n\n")
-		s.WriteString(styles.MutedStyle.Render("  Esc to cancel"))
+		s.WriteString(styles.MutedStyle.Render("  ↑/↓ select member • Esc to cancel"))
 	case "rename":
 		s.WriteString("New Name:\n")
 		s.WriteString(m.infoInput.View())
@@ -982,8 +2905,12 @@ func (m model) overlayInfo() string {
 		s.WriteString("\n\n" + styles.MutedStyle.Render("Enter to add, Esc to cancel"))
 	}
 
+	if m.infoError != "" {
+		s.WriteString("\n\n" + styles.ErrorStyle.Render(m.infoError))
+	}
+
 	modal := lipgloss.NewStyle().
-		Width(width).Height(height).
+		Width(width).
 		Border(lipgloss.RoundedBorder()).
 		BorderForeground(styles.ActiveBorder).
 		Background(styles.BgColor).
@@ -993,6 +2920,48 @@ func (m model) overlayInfo() string {
 	return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center, modal)
 }
 
+func (m model) overlayTOTP() string {
+	var s strings.Builder
+	s.WriteString(styles.TitleStyle.Render("Two-Factor Authentication") + "\n\n")
+
+	switch m.totpMode {
+	case "":
+		s.WriteString(styles.MutedStyle.Render("Generating secret..."))
+	case "setup":
+		s.WriteString("Scan with your authenticator app:\n\n")
+		if m.totpQR != "" {
+			s.WriteString(m.totpQR + "\n\n")
+		}
+		s.WriteString("Or enter the key manually:\n")
+		s.WriteString(styles.ProfileStyle.Render(m.totpSecret) + "\n\n")
+		s.WriteString("Code: " + m.totpInput.View())
+		s.WriteString("\n\n" + styles.MutedStyle.Render("Enter to confirm, Esc to cancel"))
+	case "recovery":
+		s.WriteString("2FA is enabled. Save these recovery codes,\n")
+		s.WriteString("each can be used once if you lose your device:\n\n")
+		for _, code := range m.totpRecovery {
+			s.WriteString("  " + styles.ProfileStyle.Render(code) + "\n")
+		}
+		s.WriteString("\n" + styles.MutedStyle.Render("They will not be shown again. Enter to close"))
+	case "disable":
+		s.WriteString("2FA is enabled. Enter a code to disable it:\n\n")
+		s.WriteString("Code: " + m.totpInput.View())
+		s.WriteString("\n\n" + styles.MutedStyle.Render("Enter to disable, Esc to cancel"))
+	}
+
+	if m.totpError != "" {
+		s.WriteString("\n\n" + styles.ErrorStyle.Render(m.totpError))
+	}
+
+	modal := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(styles.ActiveBorder).
+		Padding(1, 2).
+		Render(s.String())
+
+	return lipgloss.Place(m.width, m.height, lipgloss.Center, lipgloss.Center, modal)
+}
+
 func (m model) sidebarView() string {
 	var s strings.Builder
 
@@ -1005,11 +2974,27 @@ func (m model) sidebarView() string {
 
 	s.WriteString(styles.TitleStyle.Render(fmt.Sprintf("%s (%s)", m.username, profileName)))
 	s.WriteString("\n\n")
+	if m.confirmLogout {
+		s.WriteString(styles.ErrorStyle.Render(fmt.Sprintf("%d unsent messages will be lost.\nPress L again to log out.", len(m.outbox))))
+		s.WriteString("\n\n")
+	}
 
 	if len(m.conversations) == 0 {
 		s.WriteString(styles.MutedStyle.Render("No conversations.\n'n' to create."))
 	} else {
+		archived := m.archivedCount()
 		for i, conv := range m.conversations {
+			if conv.Archived && (i == 0 || !m.conversations[i-1].Archived) {
+				hint := "A to show"
+				if m.showArchived {
+					hint = "A to hide"
+				}
+				s.WriteString(styles.MutedStyle.Render(fmt.Sprintf("\nArchived (%d) · %s", archived, hint)) + "\n")
+				if !m.showArchived {
+					break
+				}
+			}
+
 			name := ""
 			if conv.Name != nil && *conv.Name != "" {
 				name = *conv.Name
@@ -1023,14 +3008,36 @@ func (m model) sidebarView() string {
 			if conv.IsGroup {
 				icon = "👥"
 			}
+			if conv.Pinned && !conv.Archived {
+				icon = "📌"
+			}
 
-			// Unread Badge
+			// Unread Badge, quieter when muted
 			unread := ""
 			if conv.UnreadCount > 0 {
-				unread = styles.ErrorStyle.Render(fmt.Sprintf(" (%d)", conv.UnreadCount))
+				badge := styles.ErrorStyle
+				if conv.muted() {
+					badge = styles.MutedStyle
+				}
+				unread = badge.Render(fmt.Sprintf(" (%d)", conv.UnreadCount))
+			}
+			if conv.mentioned {
+				unread += styles.MentionStyle.Render("@")
+			}
+			if conv.muted() {
+				unread += " 🔕"
+			}
+			switch m.notifier.Config.Mode(conv.ID) {
+			case notify.ModeMentions:
+				unread += styles.MutedStyle.Render(" @only")
+			case notify.ModeOff:
+				unread += " 🔇"
 			}
 
 			line := fmt.Sprintf("%s %s%s", icon, name, unread)
+			if preview := m.previewLine(conv); preview != "" {
+				line += "\n" + styles.MutedStyle.Render(preview)
+			}
 
 			if i == m.selectedConv {
 				s.WriteString(styles.SelectedItemStyle.Render(line) + "\n")
@@ -1044,6 +3051,30 @@ func (m model) sidebarView() string {
 	return style.Render(s.String())
 }
 
+// previewLine summarises a conversation's last message for the sidebar,
+// e.g. "alice: see you then · 5m".
+func (m model) previewLine(conv Conversation) string {
+	msg := conv.LastMessage
+	if msg == nil {
+		return ""
+	}
+	text := strings.Join(strings.Fields(msg.preview()), " ")
+	switch {
+	case msg.isSystem():
+	case msg.SenderID == m.userID:
+		text = "You: " + text
+	case conv.IsGroup && msg.SenderUsername != "":
+		text = msg.SenderUsername + ": " + text
+	}
+
+	suffix := " · " + formatRelativeTime(msg.CreatedAt)
+	width := m.sidebarWidth - 8 - len([]rune(suffix)) // Borders, padding and indent
+	if runes := []rune(text); width > 1 && len(runes) > width {
+		text = string(runes[:width-1]) + "…"
+	}
+	return "   " + text + suffix
+}
+
 func (m model) chatWindowView() string {
 	if m.showNewConv {
 		// Overlay logic could be handled here, or just render over the chat
@@ -1068,9 +3099,16 @@ func (m model) chatWindowView() string {
 
 	// Header
 	headerText := "💬 " + m.currentConvName
-	if m.isReconnecting {
+	if m.isReconnecting && m.reconnectCount > 5 {
+		headerText = "○ Offline, showing cached messages | " + m.currentConvName
+	} else if m.authRetry != "" {
+		headerText = "○ Offline: " + m.authRetry + " | " + m.currentConvName
+	} else if m.isReconnecting {
 		headerText = fmt.Sprintf("⟳ Reconnecting (%d/5)... | %s", m.reconnectCount, m.currentConvName)
 	}
+	if m.rawMarkdown {
+		headerText += styles.MutedStyle.Render(" · raw")
+	}
 	header := styles.HeaderStyle.Render(headerText)
 
 	// Typing Status
@@ -1083,6 +3121,14 @@ func (m model) chatWindowView() string {
 		typingStatus = styles.MutedStyle.Render(fmt.Sprintf(" %s typing...", strings.Join(names, ", ")))
 	}
 
+	if note := m.transferStatus(); note != "" {
+		if typingStatus == "" {
+			typingStatus = " " + note
+		} else {
+			typingStatus += "  " + note
+		}
+	}
+
 	// Footer (Input)
 	footerContent := m.messageInput.View()
 	if typingStatus != "" {
@@ -1121,15 +3167,22 @@ action := m.authAction
 		s.WriteString("Login / → Register\n\n")
 	}
 
-	s.WriteString("Server:   " + m.serverInput.View() + "\n")
-	s.WriteString("Username: " + m.usernameInput.View() + "\n")
-	s.WriteString("Password: " + m.passwordInput.View() + "\n\n")
+	if m.authChallenge {
+		s.WriteString("Two-factor authentication is enabled for this account.\n\n")
+		s.WriteString("Code: " + m.totpInput.View() + "\n\n")
+	} else {
+		s.WriteString("Server:   " + m.serverInput.View() + "\n")
+		s.WriteString("Username: " + m.usernameInput.View() + "\n")
+		s.WriteString("Password: " + m.passwordInput.View() + "\n\n")
+	}
 
 	if m.authError != "" {
 		s.WriteString(styles.ErrorStyle.Render(m.authError) + "\n")
 	}
 
-	if m.isLoading {
+	if m.authChallenge && !m.isLoading {
+		s.WriteString(styles.MutedStyle.Render("Enter to Verify • Esc to Cancel"))
+	} else if m.isLoading {
 		s.WriteString(styles.MutedStyle.Render("Connecting..."))
 	} else {
 		s.WriteString(styles.MutedStyle.Render("Enter to Submit • Tab to Switch Field • Ctrl+R Toggle Mode"))
@@ -1157,6 +3210,10 @@ func (m model) newConversationView() string {
 		}
 	}
 
+	if !m.newConvIsGroup && len(m.newConvUsers) > 1 {
+		s.WriteString("\n" + styles.ErrorStyle.Render("A DM has exactly one other user. Ctrl+G for a group.") + "\n")
+	}
+
 	s.WriteString("\n(Ctrl+S to Create, Esc to Cancel)")
 
 	// Render as a centered modal
@@ -1169,6 +3226,7 @@ func main() {
 	// Parse flags
 	flag.StringVar(&profileName, "profile", "default", "Profile name for session isolation")
 	flag.BoolVar(&debug.Enabled, "debug", false, "Enable debug logging to debug.log")
+	flag.BoolVar(&insecureTLS, "insecure", false, "Skip TLS certificate verification (self-signed dev servers)")
 	flag.Parse()
 
 	serverURL := os.Getenv("CLDZMSG_SERVER")
@@ -1,40 +1,196 @@
 package main
 
 import (
-	"log"
+	"crypto/tls"
+	"flag"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
+	"github.com/cloudzz-dev/cldzmsg/internal/server/admin"
+	"github.com/cloudzz-dev/cldzmsg/internal/server/attachments"
+	"github.com/cloudzz-dev/cldzmsg/internal/server/auth"
+	"github.com/cloudzz-dev/cldzmsg/internal/server/certs"
+	"github.com/cloudzz-dev/cldzmsg/internal/server/config"
 	"github.com/cloudzz-dev/cldzmsg/internal/server/handlers"
+	"github.com/cloudzz-dev/cldzmsg/internal/server/metrics"
 	"github.com/cloudzz-dev/cldzmsg/internal/server/ratelimit"
 	"github.com/cloudzz-dev/cldzmsg/internal/server/storage"
 	"github.com/cloudzz-dev/cldzmsg/internal/server/ws"
 )
 
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
 func main() {
+	configPath := flag.String("config", os.Getenv("CLDZMSG_CONFIG"), "Path to YAML config file")
+	flag.Parse()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fatal("Invalid configuration", err)
+	}
+
+	// JSON logs; the level can be changed on SIGHUP
+	logLevel := new(slog.LevelVar)
+	level, _ := config.ParseLogLevel(cfg.LogLevel)
+	logLevel.Set(level)
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})))
+
 	// Initialize Storage (DB)
-	store := storage.New()
+	store := storage.New(cfg.DatabaseURL)
 	defer store.Close()
 
 	// Initialize Rate Limiter
-	limiter := ratelimit.New()
+	limiter := ratelimit.New(cfg.RateLimit.MaxConnectionsPerIP, cfg.RateLimit.AuthAttemptsPerMin)
+
+	// Allowed browser origins for the WebSocket endpoint
+	origins := handlers.NewOriginPolicy(cfg.AllowedOrigins)
+
+	// Password hashing
+	hasher := auth.NewPasswordHasherFromConfig(cfg.Argon2)
+
+	// Shared files, minus any left behind by deleted messages
+	files, err := attachments.New(cfg.Attachments.Dir, attachmentLimits(cfg.Attachments))
+	if err != nil {
+		fatal("Failed to open attachments directory", err)
+	}
+	if keys, err := store.AttachmentKeys(); err != nil {
+		slog.Error("Failed to list attachments, skipping cleanup", "error", err)
+	} else if n, err := files.Prune(func(key string) bool { return keys[key] }); err != nil {
+		slog.Error("Failed to clean up attachments", "error", err)
+	} else if n > 0 {
+		slog.Info("Removed orphaned attachments", "count", n)
+	}
 
 	// Initialize WebSocket Hub
-	hub := ws.NewHub(store)
+	hub := ws.NewHub(store, hasher)
+	hub.Files = files
 	go hub.Run()
+	metrics.RegisterAuthenticatedUsers(hub.AuthenticatedUsers)
 
 	// Routes
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		handlers.HandleWebSocket(hub, limiter, w, r)
+		handlers.HandleWebSocket(hub, limiter, origins, w, r)
 	})
 
-	http.HandleFunc("/health", handlers.HealthCheck)
+	health := handlers.NewHealth(version,
+		handlers.Check{Name: "database", Run: store.Ping},
+		handlers.Check{Name: "hub", Run: hub.Ping},
+	)
+	health.AuthenticatedUsers = hub.AuthenticatedUsers
+	http.HandleFunc("/livez", health.Livez)
+	http.HandleFunc("/readyz", health.Readyz)
+	http.HandleFunc("/health", health.Livez) // Kept for existing scripts
+	if cfg.HealthPort != "" {
+		// Plain HTTP, so probes work whether or not the main port uses TLS.
+		// Metrics live only here, off the public port.
+		probes := http.NewServeMux()
+		probes.HandleFunc("/livez", health.Livez)
+		probes.HandleFunc("/readyz", health.Readyz)
+		probes.Handle("/metrics", metrics.Handler())
+		go func() {
+			slog.Info("Health listener starting", "port", cfg.HealthPort)
+			fatal("Health listener stopped", http.ListenAndServe(":"+cfg.HealthPort, probes))
+		}()
+	}
+
+	adminAPI := admin.New(store, hub, limiter, cfg.Admin.Token)
+	http.Handle(admin.Prefix+"/", adminAPI.Handler())
+
+	srv := &http.Server{Addr: ":" + cfg.Port}
+
+	tlsConfig, reloader, err := loadTLSConfig(cfg.TLS)
+	if err != nil {
+		fatal("Failed to load TLS certificate", err)
+	}
+
+	// SIGHUP reloads the config file and certificate without dropping connections
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			next, err := config.Load(*configPath)
+			if err != nil {
+				slog.Error("Config reload failed, keeping current settings", "error", err)
+			} else {
+				limiter.SetLimits(next.RateLimit.MaxConnectionsPerIP, next.RateLimit.AuthAttemptsPerMin)
+				origins.Set(next.AllowedOrigins)
+				adminAPI.SetToken(next.Admin.Token)
+				files.SetLimits(attachmentLimits(next.Attachments))
+				level, _ := config.ParseLogLevel(next.LogLevel)
+				logLevel.Set(level)
+				if changed := cfg.RestartRequired(next); len(changed) > 0 {
+					slog.Warn("Config reloaded; restart required to apply some settings", "settings", strings.Join(changed, ", "))
+				} else {
+					slog.Info("Config reloaded", "log_level", next.LogLevel)
+				}
+			}
+
+			if reloader != nil {
+				if err := reloader.Reload(); err != nil {
+					slog.Error("TLS reload failed, keeping previous certificate", "error", err)
+				} else {
+					slog.Info("TLS certificate reloaded")
+				}
+			}
+		}
+	}()
+
+	if tlsConfig != nil {
+		srv.TLSConfig = tlsConfig
+		slog.Info("Server starting", "port", cfg.Port, "tls", true, "version", version)
+		fatal("Server stopped", srv.ListenAndServeTLS("", ""))
+	}
+
+	slog.Info("Server starting", "port", cfg.Port, "tls", false, "version", version)
+	fatal("Server stopped", srv.ListenAndServe())
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// attachmentLimits converts the configured sizes from MiB to bytes.
+func attachmentLimits(cfg config.AttachmentsConfig) attachments.Limits {
+	const mib = 1024 * 1024
+	return attachments.Limits{
+		MaxFileSize: int64(cfg.MaxFileMB) * mib,
+		UserQuota:   int64(cfg.UserQuotaMB) * mib,
+		TotalQuota:  int64(cfg.TotalQuotaMB) * mib,
+	}
+}
+
+// loadTLSConfig enables native TLS from a cert/key pair, which can be
+// reloaded via the returned Reloader, or from a self-signed development
+// certificate. It returns nil for plain HTTP behind a TLS-terminating proxy.
+func loadTLSConfig(cfg config.TLSConfig) (*tls.Config, *certs.Reloader, error) {
+	if cfg.CertFile != "" {
+		reloader, err := certs.NewReloader(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.GetCertificate,
+		}, reloader, nil
+	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "3567"
+	if cfg.SelfSigned {
+		cert, err := certs.SelfSigned(cfg.Hosts)
+		if err != nil {
+			return nil, nil, err
+		}
+		slog.Warn("Using a self-signed certificate, do not use in production")
+		return &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{*cert},
+		}, nil, nil
 	}
 
-	log.Printf("Server starting on :%s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
-}
\ No newline at end of file
+	return nil, nil, nil
+}
@@ -11,6 +11,7 @@ NC='\033[0m' # No Color
 
 # Configuration
 PORT="${PORT:-3567}"
+HEALTH_PORT="${HEALTH_PORT:-3568}"
 COMPOSE_FILE="docker-compose.yml"
 
 echo -e "${BLUE}"
@@ -179,6 +180,15 @@ deploy() {
         exit 1
     fi
     
+    # The new server must not start against an old schema
+    echo -e "${BLUE}Starting database...${NC}"
+    if ! $COMPOSE_CMD up -d postgres; then
+        echo -e "${RED}Error: Failed to start database${NC}"
+        exit 1
+    fi
+    wait_for_database
+    run_migrations
+
     echo -e "${BLUE}Starting services...${NC}"
     if ! $COMPOSE_CMD up -d; then
         echo -e "${RED}Error: Failed to start services${NC}"
@@ -188,34 +198,33 @@ deploy() {
     echo ""
 }
 
-# =============================================================================
-# Health Check
-# =============================================================================
-
-health_check() {
-    echo -e "${CYAN}Running health checks...${NC}"
-    
-    # Wait for containers to be ready
+wait_for_database() {
     local max_attempts=30
     local attempt=1
-    
+
     echo -e "${BLUE}Waiting for database to be healthy...${NC}"
     while [ $attempt -le $max_attempts ]; do
         if docker inspect cldzmsg-db --format='{{.State.Health.Status}}' 2>/dev/null | grep -q "healthy"; then
             echo -e "  ${GREEN}✓${NC} Database is healthy"
-            break
+            return
         fi
         echo -e "  Attempt $attempt/$max_attempts..."
         sleep 2
         ((attempt++))
     done
-    
-    if [ $attempt -gt $max_attempts ]; then
-        echo -e "  ${RED}✗${NC} Database health check timed out"
-        echo -e "${RED}Checking container logs:${NC}"
-        $COMPOSE_CMD logs --tail=20 postgres
-        exit 1
-    fi
+
+    echo -e "  ${RED}✗${NC} Database health check timed out"
+    echo -e "${RED}Checking container logs:${NC}"
+    $COMPOSE_CMD logs --tail=20 postgres
+    exit 1
+}
+
+# =============================================================================
+# Health Check
+# =============================================================================
+
+health_check() {
+    echo -e "${CYAN}Running health checks...${NC}"
     
     # Check server container
     if docker ps --format '{{.Names}}' | grep -q "cldzmsg-server"; then
@@ -227,17 +236,36 @@ health_check() {
         exit 1
     fi
     
-    # Test health endpoint
+    # Test readiness endpoint (checks database and hub)
     sleep 2
-    if curl -sf "http://localhost:$PORT/health" > /dev/null 2>&1; then
-        echo -e "  ${GREEN}✓${NC} Health endpoint responding"
+    if $COMPOSE_CMD exec -T server wget -q -O /dev/null "http://localhost:$HEALTH_PORT/readyz" > /dev/null 2>&1; then
+        echo -e "  ${GREEN}✓${NC} Server is ready"
     else
-        echo -e "  ${YELLOW}!${NC} Health endpoint not responding yet (may still be starting)"
+        echo -e "  ${YELLOW}!${NC} Readiness check not passing yet (may still be starting)"
+        $COMPOSE_CMD exec -T server wget -q -O - "http://localhost:$HEALTH_PORT/readyz" 2>/dev/null && echo ""
     fi
     
     echo ""
 }
 
+# =============================================================================
+# Database Migrations
+# =============================================================================
+
+run_migrations() {
+    echo -e "${CYAN}Applying database migrations...${NC}"
+
+    # A one-off container from the new image, before the server starts
+    if $COMPOSE_CMD run --rm --no-deps -T server ./cldzadmin migrate; then
+        echo -e "  ${GREEN}✓${NC} Database schema is current"
+    else
+        echo -e "  ${RED}✗${NC} Migrations failed"
+        exit 1
+    fi
+
+    echo ""
+}
+
 # =============================================================================
 # Commit and Push
 # =============================================================================
@@ -286,7 +314,7 @@ print_summary() {
     echo -e "${NC}"
     echo -e "  ${GREEN}✓${NC} Server running on port ${CYAN}$PORT${NC}"
     echo -e "  ${GREEN}✓${NC} WebSocket endpoint: ${CYAN}ws://localhost:$PORT/ws${NC}"
-    echo -e "  ${GREEN}✓${NC} Health check: ${CYAN}http://localhost:$PORT/health${NC}"
+    echo -e "  ${GREEN}✓${NC} Readiness: ${CYAN}http://localhost:$HEALTH_PORT/readyz${NC} inside the container (liveness: /livez)"
     echo ""
     echo -e "  ${BLUE}To connect with the client:${NC}"
     echo -e "    ./cldzmsg"
@@ -0,0 +1,376 @@
+// Package cache keeps a profile's conversations and recent messages in a
+// local SQLite database, so the client can show them before the server
+// answers and while it is unreachable.
+//
+// Records are opaque JSON sealed with the same machine key as
+// session.json; only IDs and timestamps are stored in the clear, for
+// ordering and sync.
+package cache
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cloudzz-dev/cldzmsg/internal/client/session"
+
+	_ "modernc.org/sqlite"
+)
+
+const fileName = "cache.db"
+
+// MaxMessages is how many messages are kept per conversation.
+const MaxMessages = 500
+
+const schema = `
+CREATE TABLE IF NOT EXISTS meta (
+	key TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS conversations (
+	id INTEGER PRIMARY KEY,
+	position INTEGER NOT NULL,
+	data TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS messages (
+	id INTEGER PRIMARY KEY,
+	conversation_id INTEGER NOT NULL,
+	created_at INTEGER NOT NULL,
+	data TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_messages_conversation ON messages(conversation_id, created_at);
+CREATE TABLE IF NOT EXISTS outbox (
+	client_id TEXT PRIMARY KEY,
+	conversation_id INTEGER NOT NULL,
+	created_at INTEGER NOT NULL,
+	failed INTEGER NOT NULL DEFAULT 0,
+	data TEXT NOT NULL
+);
+`
+
+// Record is one cached conversation or message. Data is the client's JSON
+// for it.
+type Record struct {
+	ID             int
+	ConversationID int
+	CreatedAt      time.Time
+	Data           []byte
+}
+
+// Cache is a profile's local store. A nil *Cache is valid and stores
+// nothing, so callers don't have to check whether it could be opened.
+type Cache struct {
+	db *sql.DB
+}
+
+// Open opens the cache in dir for owner, an identifier of the server and
+// account. A cache written for a different owner is emptied first.
+func Open(dir, owner string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, fileName)
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(2000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	os.Chmod(path, 0600)
+
+	c := &Cache{db: db}
+	if current, _ := c.Meta("owner"); current != owner {
+		if err := c.reset(); err != nil {
+			db.Close()
+			return nil, err
+		}
+		if err := c.SetMeta("owner", owner); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Remove deletes the cache in dir, e.g. on logout.
+func Remove(dir string) {
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		os.Remove(filepath.Join(dir, fileName+suffix))
+	}
+}
+
+func (c *Cache) Close() error {
+	if c == nil {
+		return nil
+	}
+	return c.db.Close()
+}
+
+func (c *Cache) reset() error {
+	for _, table := range []string{"meta", "conversations", "messages", "outbox"} {
+		if _, err := c.db.Exec("DELETE FROM " + table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Meta returns a stored setting, or "" if unset.
+func (c *Cache) Meta(key string) (string, error) {
+	if c == nil {
+		return "", nil
+	}
+	var v string
+	err := c.db.QueryRow("SELECT value FROM meta WHERE key = ?", key).Scan(&v)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return v, err
+}
+
+func (c *Cache) SetMeta(key, value string) error {
+	if c == nil {
+		return nil
+	}
+	_, err := c.db.Exec(
+		"INSERT INTO meta (key, value) VALUES (?, ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value",
+		key, value,
+	)
+	return err
+}
+
+// SetConversations replaces the conversation list, in order. Messages of
+// conversations no longer listed are dropped.
+func (c *Cache) SetConversations(recs []Record) error {
+	if c == nil {
+		return nil
+	}
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM conversations"); err != nil {
+		return err
+	}
+	for i, r := range recs {
+		data, err := session.Encrypt(r.Data)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO conversations (id, position, data) VALUES (?, ?, ?)", r.ID, i, data); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM messages WHERE conversation_id NOT IN (SELECT id FROM conversations)"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Conversations returns the cached list in its saved order.
+func (c *Cache) Conversations() ([]Record, error) {
+	if c == nil {
+		return nil, nil
+	}
+	rows, err := c.db.Query("SELECT id, data FROM conversations ORDER BY position")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recs []Record
+	for rows.Next() {
+		var r Record
+		var data string
+		if err := rows.Scan(&r.ID, &data); err != nil {
+			return nil, err
+		}
+		if r.Data, err = session.Decrypt(data); err != nil {
+			continue // Sealed on another machine
+		}
+		r.ConversationID = r.ID
+		recs = append(recs, r)
+	}
+	return recs, rows.Err()
+}
+
+// PutMessages stores messages of one conversation, replacing any cached
+// ones first if replace is set, and trims it to MaxMessages.
+func (c *Cache) PutMessages(convID int, recs []Record, replace bool) error {
+	if c == nil {
+		return nil
+	}
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if replace {
+		if _, err := tx.Exec("DELETE FROM messages WHERE conversation_id = ?", convID); err != nil {
+			return err
+		}
+	}
+	for _, r := range recs {
+		data, err := session.Encrypt(r.Data)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO messages (id, conversation_id, created_at, data) VALUES (?, ?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET data = excluded.data
+		`, r.ID, convID, r.CreatedAt.UnixNano(), data); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`
+		DELETE FROM messages WHERE conversation_id = ? AND id NOT IN (
+			SELECT id FROM messages WHERE conversation_id = ? ORDER BY id DESC LIMIT ?
+		)
+	`, convID, convID, MaxMessages); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Messages returns a conversation's cached messages, oldest first.
+func (c *Cache) Messages(convID int) ([]Record, error) {
+	if c == nil {
+		return nil, nil
+	}
+	rows, err := c.db.Query(
+		"SELECT id, created_at, data FROM messages WHERE conversation_id = ? ORDER BY id",
+		convID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recs []Record
+	for rows.Next() {
+		var r Record
+		var created int64
+		var data string
+		if err := rows.Scan(&r.ID, &created, &data); err != nil {
+			return nil, err
+		}
+		if r.Data, err = session.Decrypt(data); err != nil {
+			continue
+		}
+		r.ConversationID = convID
+		r.CreatedAt = time.Unix(0, created)
+		recs = append(recs, r)
+	}
+	return recs, rows.Err()
+}
+
+// LastMessageID is the newest message ID cached for a conversation, 0 if
+// none. New messages always get higher IDs, so the server only needs to
+// send those after it.
+func (c *Cache) LastMessageID(convID int) (int, error) {
+	if c == nil {
+		return 0, nil
+	}
+	var id int
+	err := c.db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM messages WHERE conversation_id = ?", convID).Scan(&id)
+	return id, err
+}
+
+func (c *Cache) DeleteMessage(id int) error {
+	if c == nil {
+		return nil
+	}
+	_, err := c.db.Exec("DELETE FROM messages WHERE id = ?", id)
+	return err
+}
+
+// DeleteConversation forgets a conversation the user left.
+func (c *Cache) DeleteConversation(convID int) error {
+	if c == nil {
+		return nil
+	}
+	if _, err := c.db.Exec("DELETE FROM messages WHERE conversation_id = ?", convID); err != nil {
+		return err
+	}
+	_, err := c.db.Exec("DELETE FROM conversations WHERE id = ?", convID)
+	return err
+}
+
+// Outgoing is a message waiting in the outbox until the server confirms
+// it. Failed ones were rejected and wait for the user to retry them.
+type Outgoing struct {
+	ClientID       string
+	ConversationID int
+	CreatedAt      time.Time
+	Failed         bool
+	Data           []byte
+}
+
+// Queue adds a message to the outbox, or updates it if already queued.
+func (c *Cache) Queue(o Outgoing) error {
+	if c == nil {
+		return nil
+	}
+	data, err := session.Encrypt(o.Data)
+	if err != nil {
+		return err
+	}
+	_, err = c.db.Exec(`
+		INSERT INTO outbox (client_id, conversation_id, created_at, failed, data) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (client_id) DO UPDATE SET failed = excluded.failed, data = excluded.data
+	`, o.ClientID, o.ConversationID, o.CreatedAt.UnixNano(), o.Failed, data)
+	return err
+}
+
+// Outbox returns queued messages in the order they were written.
+func (c *Cache) Outbox() ([]Outgoing, error) {
+	if c == nil {
+		return nil, nil
+	}
+	rows, err := c.db.Query("SELECT client_id, conversation_id, created_at, failed, data FROM outbox ORDER BY created_at")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Outgoing
+	for rows.Next() {
+		var o Outgoing
+		var created int64
+		var data string
+		if err := rows.Scan(&o.ClientID, &o.ConversationID, &created, &o.Failed, &data); err != nil {
+			return nil, err
+		}
+		if o.Data, err = session.Decrypt(data); err != nil {
+			continue
+		}
+		o.CreatedAt = time.Unix(0, created)
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+func (c *Cache) SetFailed(clientID string, failed bool) error {
+	if c == nil {
+		return nil
+	}
+	_, err := c.db.Exec("UPDATE outbox SET failed = ? WHERE client_id = ?", failed, clientID)
+	return err
+}
+
+// Dequeue removes a message the server has confirmed or the user dropped.
+func (c *Cache) Dequeue(clientID string) error {
+	if c == nil {
+		return nil
+	}
+	_, err := c.db.Exec("DELETE FROM outbox WHERE client_id = ?", clientID)
+	return err
+}
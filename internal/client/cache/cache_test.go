@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func msg(id, convID int, at time.Time) Record {
+	return Record{ID: id, ConversationID: convID, CreatedAt: at, Data: []byte(`{"content":"secret text"}`)}
+}
+
+func TestMessagesRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	c, err := Open(dir, "wss://example|alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.SetConversations([]Record{{ID: 1, Data: []byte(`{"id":1}`)}, {ID: 2, Data: []byte(`{"id":2}`)}}); err != nil {
+		t.Fatal(err)
+	}
+	base := time.Now()
+	// Messages come back in ID order whatever order they were stored in
+	if err := c.PutMessages(1, []Record{msg(10, 1, base.Add(time.Second)), msg(5, 1, base), msg(11, 1, base.Add(2*time.Second))}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	recs, err := c.Messages(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 3 || recs[0].ID != 5 || recs[1].ID != 10 || string(recs[2].Data) != `{"content":"secret text"}` {
+		t.Fatalf("Messages = %+v", recs)
+	}
+	if last, _ := c.LastMessageID(1); last != 11 {
+		t.Errorf("LastMessageID = %d, want 11", last)
+	}
+
+	c.DeleteMessage(11)
+	if last, _ := c.LastMessageID(1); last != 10 {
+		t.Errorf("LastMessageID after delete = %d, want 10", last)
+	}
+
+	// Dropping a conversation from the list drops its messages
+	c.SetConversations([]Record{{ID: 2, Data: []byte(`{"id":2}`)}})
+	if recs, _ := c.Messages(1); len(recs) != 0 {
+		t.Errorf("expected messages of removed conversation to be gone, got %d", len(recs))
+	}
+
+	data, _ := os.ReadFile(filepath.Join(dir, fileName))
+	if strings.Contains(string(data), "secret text") {
+		t.Error("message content stored in the clear")
+	}
+}
+
+func TestTrimAndReplace(t *testing.T) {
+	c, err := Open(t.TempDir(), "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetConversations([]Record{{ID: 1, Data: []byte(`{}`)}})
+
+	base := time.Now()
+	var recs []Record
+	for i := 1; i <= MaxMessages+10; i++ {
+		recs = append(recs, msg(i, 1, base.Add(time.Duration(i)*time.Second)))
+	}
+	c.PutMessages(1, recs, false)
+	got, _ := c.Messages(1)
+	if len(got) != MaxMessages || got[0].ID != 11 {
+		t.Fatalf("kept %d messages starting at %d, want %d starting at 11", len(got), got[0].ID, MaxMessages)
+	}
+
+	c.PutMessages(1, []Record{msg(900, 1, base)}, true)
+	if got, _ := c.Messages(1); len(got) != 1 {
+		t.Errorf("replace kept %d messages, want 1", len(got))
+	}
+}
+
+func TestOwnerChangeResets(t *testing.T) {
+	dir := t.TempDir()
+	c, _ := Open(dir, "server|alice")
+	c.SetConversations([]Record{{ID: 1, Data: []byte(`{}`)}})
+	c.SetMeta("user_id", "7")
+	c.Close()
+
+	c, err := Open(dir, "server|bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if convs, _ := c.Conversations(); len(convs) != 0 {
+		t.Error("another account's conversations were kept")
+	}
+	if id, _ := c.Meta("user_id"); id != "" {
+		t.Errorf("user_id = %q, want empty", id)
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	c, err := Open(t.TempDir(), "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	base := time.Now()
+	c.Queue(Outgoing{ClientID: "b", ConversationID: 1, CreatedAt: base.Add(time.Second), Data: []byte("second")})
+	c.Queue(Outgoing{ClientID: "a", ConversationID: 1, CreatedAt: base, Data: []byte("first")})
+	c.SetFailed("b", true)
+
+	out, err := c.Outbox()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 2 || out[0].ClientID != "a" || string(out[0].Data) != "first" || out[0].Failed || !out[1].Failed {
+		t.Fatalf("Outbox = %+v", out)
+	}
+
+	c.Dequeue("a")
+	if out, _ := c.Outbox(); len(out) != 1 || out[0].ClientID != "b" {
+		t.Errorf("after Dequeue = %+v", out)
+	}
+}
+
+func TestNilCache(t *testing.T) {
+	var c *Cache
+	if err := c.PutMessages(1, []Record{msg(1, 1, time.Now())}, false); err != nil {
+		t.Error(err)
+	}
+	if recs, err := c.Messages(1); recs != nil || err != nil {
+		t.Error("nil cache returned messages")
+	}
+}
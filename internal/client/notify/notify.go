@@ -0,0 +1,310 @@
+// Package notify raises desktop notifications for incoming messages.
+//
+// Settings live in notifications.json in the profile's config directory.
+// A backend decides how a notification is shown: the terminal bell, an
+// OSC 9 or OSC 777 escape sequence for terminals that turn those into
+// desktop notifications, a command such as notify-send, or a hook command
+// that receives the notification as JSON on stdin.
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Backends
+const (
+	BackendBell    = "bell"
+	BackendOSC9    = "osc9"
+	BackendOSC777  = "osc777"
+	BackendCommand = "command"
+	BackendHook    = "hook"
+	BackendNone    = "none"
+)
+
+// Per-conversation modes
+const (
+	ModeAll      = "all"
+	ModeMentions = "mentions"
+	ModeOff      = "off"
+)
+
+const configFile = "notifications.json"
+
+// Notification is one message worth telling the user about. Hooks get it
+// as JSON.
+type Notification struct {
+	ConversationID int       `json:"conversation_id"`
+	Conversation   string    `json:"conversation"`
+	Sender         string    `json:"sender"`
+	Body           string    `json:"body"`
+	Mention        bool      `json:"mention"`
+	Muted          bool      `json:"muted"` // Muted on the server; only mentions get through
+	Time           time.Time `json:"time"`
+}
+
+// Title is what the notification is headed with, e.g. "alice in Team".
+func (n Notification) Title() string {
+	if n.Conversation == "" || n.Conversation == n.Sender {
+		return n.Sender
+	}
+	return n.Sender + " in " + n.Conversation
+}
+
+type Config struct {
+	Backend string `json:"backend"`
+	// Command runs for the command backend; {title}, {body}, {sender} and
+	// {conversation} in its arguments are replaced.
+	Command []string `json:"command,omitempty"`
+	// Hook runs for the hook backend with the notification on stdin.
+	Hook []string `json:"hook,omitempty"`
+
+	MentionsOnly  bool           `json:"mentions_only"`
+	Conversations map[int]string `json:"conversations,omitempty"` // ID -> mode
+
+	// Do not disturb, as "HH:MM" local time; may wrap past midnight
+	QuietStart string `json:"quiet_start,omitempty"`
+	QuietEnd   string `json:"quiet_end,omitempty"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Backend: BackendBell,
+		Command: []string{"notify-send", "--app-name=cldzmsg", "{title}", "{body}"},
+	}
+}
+
+// LoadConfig reads notifications.json from dir. A missing file gives the
+// defaults.
+func LoadConfig(dir string) (Config, error) {
+	cfg := DefaultConfig()
+	data, err := os.ReadFile(filepath.Join(dir, configFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return DefaultConfig(), fmt.Errorf("%s: %w", configFile, err)
+	}
+	if _, _, err := cfg.quietHours(); err != nil {
+		return DefaultConfig(), fmt.Errorf("%s: %w", configFile, err)
+	}
+	return cfg, nil
+}
+
+func (c Config) Save(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, configFile), append(data, '\n'), 0600)
+}
+
+// Mode is how a conversation is filtered: its own setting, otherwise
+// mentions-only or all.
+func (c Config) Mode(convID int) string {
+	if mode, ok := c.Conversations[convID]; ok {
+		return mode
+	}
+	if c.MentionsOnly {
+		return ModeMentions
+	}
+	return ModeAll
+}
+
+// Allows applies the filters and do-not-disturb hours.
+func (c Config) Allows(n Notification) bool {
+	if c.Backend == BackendNone || c.quiet(n.Time) {
+		return false
+	}
+	switch c.Mode(n.ConversationID) {
+	case ModeOff:
+		return false
+	case ModeMentions:
+		return n.Mention
+	}
+	return n.Mention || !n.Muted
+}
+
+// quiet reports whether t falls in the do-not-disturb hours.
+func (c Config) quiet(t time.Time) bool {
+	start, end, err := c.quietHours()
+	if err != nil || start == end {
+		return false
+	}
+	now := t.Hour()*60 + t.Minute()
+	if start < end {
+		return now >= start && now < end
+	}
+	return now >= start || now < end // e.g. 22:00-07:00
+}
+
+// quietHours parses the do-not-disturb hours into minutes after midnight.
+// Unset hours are 0-0, which never matches.
+func (c Config) quietHours() (start, end int, err error) {
+	if c.QuietStart == "" && c.QuietEnd == "" {
+		return 0, 0, nil
+	}
+	if start, err = parseClock(c.QuietStart); err != nil {
+		return 0, 0, err
+	}
+	if end, err = parseClock(c.QuietEnd); err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, want HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Backend shows a notification.
+type Backend interface {
+	Notify(n Notification) error
+}
+
+// NewBackend builds the configured backend. Escape sequences are written
+// to term.
+func NewBackend(cfg Config, term io.Writer) (Backend, error) {
+	switch cfg.Backend {
+	case BackendBell, "":
+		return bell{term}, nil
+	case BackendOSC9:
+		return osc9{term}, nil
+	case BackendOSC777:
+		return osc777{term}, nil
+	case BackendCommand:
+		if len(cfg.Command) == 0 {
+			return nil, errors.New("command backend needs a command")
+		}
+		return command{cfg.Command}, nil
+	case BackendHook:
+		if len(cfg.Hook) == 0 {
+			return nil, errors.New("hook backend needs a hook command")
+		}
+		return hook{cfg.Hook}, nil
+	case BackendNone:
+		return none{}, nil
+	}
+	return nil, fmt.Errorf("unknown notification backend %q", cfg.Backend)
+}
+
+type bell struct{ w io.Writer }
+
+func (b bell) Notify(Notification) error {
+	_, err := io.WriteString(b.w, "\a")
+	return err
+}
+
+// osc9 is understood by iTerm2, Windows Terminal, kitty and others.
+type osc9 struct{ w io.Writer }
+
+func (o osc9) Notify(n Notification) error {
+	_, err := fmt.Fprintf(o.w, "\x1b]9;%s\x07", sanitize(n.Title()+": "+n.Body))
+	return err
+}
+
+// osc777 is understood by urxvt, foot, WezTerm and VTE-based terminals.
+type osc777 struct{ w io.Writer }
+
+func (o osc777) Notify(n Notification) error {
+	title := strings.ReplaceAll(sanitize(n.Title()), ";", ",")
+	_, err := fmt.Fprintf(o.w, "\x1b]777;notify;%s;%s\x07", title, sanitize(n.Body))
+	return err
+}
+
+// sanitize drops control characters so message text can't end the escape
+// sequence early or inject its own.
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || (r >= 0x80 && r < 0xa0) {
+			if r == '\n' || r == '\t' {
+				return ' '
+			}
+			return -1
+		}
+		return r
+	}, s)
+}
+
+type command struct{ argv []string }
+
+func (c command) Notify(n Notification) error {
+	r := strings.NewReplacer(
+		"{title}", n.Title(),
+		"{body}", n.Body,
+		"{sender}", n.Sender,
+		"{conversation}", n.Conversation,
+	)
+	args := make([]string, len(c.argv))
+	for i, a := range c.argv {
+		args[i] = r.Replace(a)
+	}
+	return start(exec.Command(args[0], args[1:]...))
+}
+
+type hook struct{ argv []string }
+
+func (h hook) Notify(n Notification) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(h.argv[0], h.argv[1:]...)
+	cmd.Stdin = strings.NewReader(string(data) + "\n")
+	return start(cmd)
+}
+
+// start runs cmd without blocking the UI; its output is discarded.
+func start(cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	go cmd.Wait()
+	return nil
+}
+
+type none struct{}
+
+func (none) Notify(Notification) error { return nil }
+
+// Notifier filters notifications and hands the rest to a backend.
+type Notifier struct {
+	Config  Config
+	backend Backend
+}
+
+func New(cfg Config, term io.Writer) (*Notifier, error) {
+	b, err := NewBackend(cfg, term)
+	if err != nil {
+		return nil, err
+	}
+	return &Notifier{Config: cfg, backend: b}, nil
+}
+
+// Notify shows n unless a filter or do-not-disturb suppresses it.
+func (nt *Notifier) Notify(n Notification) error {
+	if n.Time.IsZero() {
+		n.Time = time.Now()
+	}
+	if !nt.Config.Allows(n) {
+		return nil
+	}
+	return nt.backend.Notify(n)
+}
@@ -0,0 +1,100 @@
+package notify
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+)
+
+func at(hour, minute int) time.Time {
+	return time.Date(2025, 1, 1, hour, minute, 0, 0, time.Local)
+}
+
+func TestAllows(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Conversations = map[int]string{1: ModeOff, 2: ModeMentions}
+
+	tests := []struct {
+		name string
+		n    Notification
+		want bool
+	}{
+		{"plain", Notification{ConversationID: 3, Time: at(12, 0)}, true},
+		{"off", Notification{ConversationID: 1, Mention: true, Time: at(12, 0)}, false},
+		{"mentions only, no mention", Notification{ConversationID: 2, Time: at(12, 0)}, false},
+		{"mentions only, mention", Notification{ConversationID: 2, Mention: true, Time: at(12, 0)}, true},
+		{"muted", Notification{ConversationID: 3, Muted: true, Time: at(12, 0)}, false},
+		{"muted mention", Notification{ConversationID: 3, Muted: true, Mention: true, Time: at(12, 0)}, true},
+	}
+	for _, tt := range tests {
+		if got := cfg.Allows(tt.n); got != tt.want {
+			t.Errorf("%s: Allows = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	cfg.MentionsOnly = true
+	if cfg.Allows(Notification{ConversationID: 3, Time: at(12, 0)}) {
+		t.Error("mentions_only should apply to conversations without their own mode")
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	cfg := Config{QuietStart: "22:00", QuietEnd: "07:30"}
+	for _, tt := range []struct {
+		t    time.Time
+		want bool
+	}{
+		{at(21, 59), false},
+		{at(22, 0), true},
+		{at(3, 0), true},
+		{at(7, 29), true},
+		{at(7, 30), false},
+	} {
+		if got := cfg.quiet(tt.t); got != tt.want {
+			t.Errorf("quiet(%s) = %v, want %v", tt.t.Format("15:04"), got, tt.want)
+		}
+	}
+
+	day := Config{QuietStart: "09:00", QuietEnd: "17:00"}
+	if !day.quiet(at(12, 0)) || day.quiet(at(18, 0)) {
+		t.Error("daytime quiet hours not applied")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	cfg, err := LoadConfig(dir)
+	if err != nil || cfg.Backend != BackendBell {
+		t.Fatalf("LoadConfig with no file = %+v, %v; want defaults", cfg, err)
+	}
+
+	cfg.Backend = BackendOSC777
+	cfg.Conversations = map[int]string{7: ModeMentions}
+	if err := cfg.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Backend != BackendOSC777 || got.Mode(7) != ModeMentions {
+		t.Errorf("round trip = %+v", got)
+	}
+
+	os.WriteFile(dir+"/"+configFile, []byte(`{"quiet_start": "25:00", "quiet_end": "07:00"}`), 0600)
+	if _, err := LoadConfig(dir); err == nil {
+		t.Error("expected an error for invalid quiet hours")
+	}
+}
+
+func TestOSCSanitized(t *testing.T) {
+	var buf bytes.Buffer
+	b, _ := NewBackend(Config{Backend: BackendOSC777}, &buf)
+	b.Notify(Notification{Sender: "eve;x", Conversation: "Team", Body: "hi\x07\x1b]0;pwned\x07"})
+
+	want := "\x1b]777;notify;eve,x in Team;hi]0;pwned\x07"
+	if got := buf.String(); got != want {
+		t.Errorf("osc777 wrote %q, want %q", got, want)
+	}
+}
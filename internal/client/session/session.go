@@ -95,6 +95,16 @@ func decrypt(encoded string) ([]byte, error) {
 	return gcm.Open(nil, nonce, ciphertext, nil)
 }
 
+// Encrypt seals data with this machine's key, for other files kept in
+// the config directory.
+func Encrypt(data []byte) (string, error) {
+	return encrypt(data)
+}
+
+func Decrypt(encoded string) ([]byte, error) {
+	return decrypt(encoded)
+}
+
 func Load(profileName string) *Session {
 	configDir := GetConfigDir(profileName)
 	if configDir == "" {
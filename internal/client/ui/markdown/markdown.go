@@ -0,0 +1,421 @@
+// Package markdown renders the Markdown subset chat messages may use:
+// **bold**, *italic*, `inline code`, fenced code blocks with syntax
+// highlighting, lists, quotes and links.
+//
+// Input is untrusted, so control characters are stripped before anything
+// else and all styling comes from lipgloss; a message can't smuggle its own
+// escape sequences to the terminal.
+package markdown
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/charmbracelet/lipgloss"
+
+	"github.com/cloudzz-dev/cldzmsg/internal/client/ui/styles"
+)
+
+// Options controls Render.
+type Options struct {
+	// Width wraps text to this many cells; code lines longer than it are
+	// cut off with an ellipsis
+	Width int
+
+	// Text, if set, is applied to plain text outside code, e.g. to
+	// highlight mentions
+	Text func(string) string
+}
+
+var (
+	headingPattern = regexp.MustCompile(`^ {0,3}#{1,6}\s+(.*?)\s*#*\s*$`)
+	quotePattern   = regexp.MustCompile(`^ {0,3}>\s?`)
+	listPattern    = regexp.MustCompile(`^(\s*)([-*+]|\d{1,9}[.)])\s+(.*)$`)
+	fencePattern   = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})\\s*([^`\\s]*)[^`]*$")
+)
+
+// Render returns src as styled text for the terminal.
+func Render(src string, opts Options) string {
+	width := max(opts.Width, 10)
+	lines := strings.Split(Sanitize(src), "\n")
+
+	var out []string
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		if m := fencePattern.FindStringSubmatch(line); m != nil {
+			// Everything up to the closing fence, or the end of the
+			// message if there is none
+			var code []string
+			for i++; i < len(lines) && !closesFence(lines[i], m[1]); i++ {
+				code = append(code, lines[i])
+			}
+			out = append(out, codeBlock(code, m[2], width)...)
+			continue
+		}
+		out = append(out, renderLine(line, width, opts)...)
+	}
+	return strings.Join(out, "\n")
+}
+
+// Sanitize removes control characters other than newlines and tabs, and
+// the bidirectional overrides that can make text display out of order.
+func Sanitize(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\t':
+			return r
+		case unicode.IsControl(r):
+			return -1
+		case r >= '\u202a' && r <= '\u202e', r >= '\u2066' && r <= '\u2069':
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// renderLine renders one line outside a code block, wrapped to width.
+func renderLine(line string, width int, opts Options) []string {
+	if m := headingPattern.FindStringSubmatch(line); m != nil {
+		return wrap("", "", inline(m[1], lipgloss.NewStyle().Bold(true), opts), width)
+	}
+
+	if loc := quotePattern.FindStringIndex(line); loc != nil {
+		depth := 0
+		for loc != nil {
+			depth++
+			line = line[loc[1]:]
+			loc = quotePattern.FindStringIndex(line)
+		}
+		bar := styles.MutedStyle.Render(strings.Repeat("│ ", depth))
+		return wrap(bar, bar, inline(line, quoteStyle, opts), width)
+	}
+
+	if m := listPattern.FindStringSubmatch(line); m != nil {
+		level := len(strings.ReplaceAll(m[1], "\t", "  ")) / 2
+		marker := m[2]
+		if strings.ContainsAny(marker, "-*+") {
+			marker = "•"
+			if level > 0 {
+				marker = "◦"
+			}
+		}
+		first := strings.Repeat("  ", level) + styles.MutedStyle.Render(marker) + " "
+		rest := strings.Repeat(" ", lipgloss.Width(first))
+		return wrap(first, rest, inline(m[3], lipgloss.NewStyle(), opts), width)
+	}
+
+	return wrap("", "", inline(line, lipgloss.NewStyle(), opts), width)
+}
+
+// wrap word-wraps text to width after the first and following line
+// prefixes.
+func wrap(first, rest, text string, width int) []string {
+	avail := max(width-lipgloss.Width(first), 1)
+	lines := strings.Split(lipgloss.NewStyle().Width(avail).Render(text), "\n")
+	for i, line := range lines {
+		line = strings.TrimRight(line, " ") // Drop the padding to width
+		if i == 0 {
+			lines[i] = first + line
+		} else {
+			lines[i] = rest + line
+		}
+	}
+	return lines
+}
+
+// closesFence reports whether line ends a block opened with fence.
+func closesFence(line, fence string) bool {
+	line = strings.TrimSpace(line)
+	return len(line) >= len(fence) && strings.Trim(line, fence[:1]) == ""
+}
+
+// --- Inline ---
+
+var (
+	quoteStyle = lipgloss.NewStyle().Foreground(styles.MutedColor)
+	codeStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("#F472B6")).Background(styles.BgColor)
+	linkStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("#60A5FA")).Underline(true)
+
+	urlPattern = regexp.MustCompile(`^(https?://|mailto:)[^\s<>]*[^\s<>.,;:!?'")\]]`)
+)
+
+// inline renders emphasis, code spans and links in s, with st as the
+// style of plain text.
+func inline(s string, st lipgloss.Style, opts Options) string {
+	var b, text strings.Builder
+	flush := func() {
+		if text.Len() == 0 {
+			return
+		}
+		t := text.String()
+		text.Reset()
+		if opts.Text != nil {
+			t = opts.Text(t)
+		}
+		b.WriteString(st.Render(t))
+	}
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && isPunct(s[i+1]):
+			text.WriteByte(s[i+1])
+			i += 2
+			continue
+
+		case c == '`':
+			n := runLength(s, i, '`')
+			if end := strings.Index(s[i+n:], s[i:i+n]); end >= 0 {
+				code := s[i+n : i+n+end]
+				if len(code) > 1 && code[0] == ' ' && code[len(code)-1] == ' ' {
+					code = code[1 : len(code)-1]
+				}
+				flush()
+				b.WriteString(codeStyle.Render(code))
+				i += n + end + n
+				continue
+			}
+			text.WriteString(s[i : i+n]) // Unclosed, so literal
+			i += n
+			continue
+
+		case c == '*' || c == '_':
+			n := min(runLength(s, i, c), 2)
+			if end := closingDelim(s, i, n); end >= 0 {
+				inner := st.Bold(true)
+				if n == 1 {
+					inner = st.Italic(true)
+				}
+				flush()
+				b.WriteString(inline(s[i+n:end], inner, opts))
+				i = end + n
+				continue
+			}
+			n = runLength(s, i, c)
+			text.WriteString(s[i : i+n])
+			i += n
+			continue
+
+		case c == '[':
+			if label, url, n := link(s[i:]); n > 0 {
+				flush()
+				b.WriteString(inline(label, linkStyle, opts))
+				if label != url {
+					b.WriteString(" " + styles.MutedStyle.Render("("+url+")"))
+				}
+				i += n
+				continue
+			}
+
+		case c == 'h' || c == 'm':
+			if i == 0 || !isWordByte(s[i-1]) {
+				if url := urlPattern.FindString(s[i:]); url != "" {
+					flush()
+					b.WriteString(linkStyle.Render(url))
+					i += len(url)
+					continue
+				}
+			}
+		}
+		text.WriteByte(c)
+		i++
+	}
+	flush()
+	return b.String()
+}
+
+// closingDelim finds the end of emphasis opened by n delimiters at s[i],
+// or returns -1. Like CommonMark, the text inside can't start or end with
+// a space, and underscores only count at word boundaries so snake_case
+// stays as it is.
+func closingDelim(s string, i, n int) int {
+	c := s[i]
+	open := i + n
+	if open >= len(s) || s[open] == ' ' || s[open] == '\t' {
+		return -1
+	}
+	if c == '_' && i > 0 && isWordByte(s[i-1]) {
+		return -1
+	}
+	delim := s[i:open]
+	for j := open + 1; j+n <= len(s); j++ {
+		if s[j] == '`' {
+			// Delimiters inside a code span don't count
+			if end := strings.Index(s[j+1:], "`"); end >= 0 {
+				j += end + 1
+			}
+			continue
+		}
+		if s[j:j+n] != delim || s[j-1] == ' ' || s[j-1] == '\t' {
+			continue
+		}
+		if j+n < len(s) && s[j+n] == c {
+			continue // Part of a longer run, e.g. **bold** inside *...*
+		}
+		if c == '_' && j+n < len(s) && isWordByte(s[j+n]) {
+			continue
+		}
+		return j
+	}
+	return -1
+}
+
+// link parses "[label](url)" at the start of s, returning how many bytes
+// it takes, or 0 if s doesn't start with a link to a safe scheme. Other
+// schemes are left as the text they were written as.
+func link(s string) (label, url string, n int) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth > 0 {
+				continue
+			}
+			if !strings.HasPrefix(s[i+1:], "(") {
+				return "", "", 0
+			}
+			end := strings.IndexByte(s[i+2:], ')')
+			if end < 0 {
+				return "", "", 0
+			}
+			url = strings.TrimSpace(s[i+2 : i+2+end])
+			if strings.ContainsAny(url, " \t") || !safeURL(url) {
+				return "", "", 0
+			}
+			return s[1:i], url, i + 3 + end
+		}
+	}
+	return "", "", 0
+}
+
+func safeURL(url string) bool {
+	lower := strings.ToLower(url)
+	for _, scheme := range []string{"https://", "http://", "mailto:"} {
+		if strings.HasPrefix(lower, scheme) && len(url) > len(scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+func runLength(s string, i int, c byte) int {
+	n := 0
+	for i+n < len(s) && s[i+n] == c {
+		n++
+	}
+	return n
+}
+
+func isPunct(c byte) bool {
+	return c < 0x80 && unicode.IsPunct(rune(c)) || strings.IndexByte("`*_~<>|+=^$", c) >= 0
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= 0x80 || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+// --- Code blocks ---
+
+// Token colours for highlighting, picked to read on dark and light
+// backgrounds. lipgloss degrades them to what the terminal supports.
+var tokenStyles = []struct {
+	typ   chroma.TokenType
+	style lipgloss.Style
+}{
+	{chroma.Comment, lipgloss.NewStyle().Foreground(styles.MutedColor).Italic(true)},
+	{chroma.Keyword, lipgloss.NewStyle().Foreground(lipgloss.Color("#C084FC"))},
+	{chroma.LiteralString, lipgloss.NewStyle().Foreground(lipgloss.Color("#FBBF24"))},
+	{chroma.LiteralNumber, lipgloss.NewStyle().Foreground(lipgloss.Color("#F87171"))},
+	{chroma.NameFunction, lipgloss.NewStyle().Foreground(lipgloss.Color("#34D399"))},
+	{chroma.NameBuiltin, lipgloss.NewStyle().Foreground(lipgloss.Color("#22D3EE"))},
+	{chroma.NameTag, lipgloss.NewStyle().Foreground(lipgloss.Color("#C084FC"))},
+	{chroma.NameAttribute, lipgloss.NewStyle().Foreground(lipgloss.Color("#34D399"))},
+	{chroma.GenericInserted, lipgloss.NewStyle().Foreground(styles.SecondaryColor)},
+	{chroma.GenericDeleted, lipgloss.NewStyle().Foreground(styles.ErrorColor)},
+}
+
+// codeBlock renders the lines of a fenced block behind a bar, highlighted
+// if lang names a known language. Lines aren't wrapped, so code keeps its
+// shape; ones too long for width are cut off.
+func codeBlock(code []string, lang string, width int) []string {
+	src := strings.ReplaceAll(strings.Join(code, "\n"), "\t", "    ")
+	lines := highlight(src, lang)
+
+	bar := styles.MutedStyle.Render("┃ ")
+	avail := max(width-lipgloss.Width(bar), 2)
+	for i, line := range lines {
+		if lipgloss.Width(line) > avail {
+			line = lipgloss.NewStyle().MaxWidth(avail-1).Render(line) + styles.MutedStyle.Render("…")
+		}
+		lines[i] = bar + line
+	}
+	if lang != "" {
+		lines = append([]string{styles.MutedStyle.Render("┏ " + lang)}, lines...)
+	}
+	return lines
+}
+
+// highlight returns src split into lines and coloured as lang.
+func highlight(src, lang string) []string {
+	var lexer chroma.Lexer
+	if lang != "" {
+		lexer = lexers.Get(lang)
+	}
+	if lexer == nil {
+		return plainCode(src)
+	}
+	it, err := chroma.Coalesce(lexer).Tokenise(nil, src)
+	if err != nil {
+		return plainCode(src)
+	}
+
+	lines := []string{""}
+	for tok := it(); tok != chroma.EOF; tok = it() {
+		st, styled := tokenStyle(tok.Type)
+		// Tokens can span lines; style each piece so lines stand alone
+		for i, piece := range strings.Split(tok.Value, "\n") {
+			if i > 0 {
+				lines = append(lines, "")
+			}
+			if piece == "" {
+				continue
+			}
+			if styled {
+				piece = st.Render(piece)
+			}
+			lines[len(lines)-1] += piece
+		}
+	}
+	// Lexers end the last line with a newline of their own
+	if len(lines) > 1 && lines[len(lines)-1] == "" && !strings.HasSuffix(src, "\n") {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func plainCode(src string) []string {
+	return strings.Split(src, "\n")
+}
+
+func tokenStyle(t chroma.TokenType) (lipgloss.Style, bool) {
+	for _, ts := range tokenStyles {
+		// Round types like Comment or LiteralString stand for their
+		// whole category or subcategory
+		switch {
+		case t == ts.typ,
+			ts.typ%1000 == 0 && t.InCategory(ts.typ),
+			ts.typ%100 == 0 && t.InSubCategory(ts.typ):
+			return ts.style, true
+		}
+	}
+	return lipgloss.Style{}, false
+}
@@ -0,0 +1,70 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Tests don't run in a terminal, so lipgloss renders no colours and the
+// output is the plain text left after the markup.
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"emphasis", "**bold** *italic* __also__ _this_", "bold italic also this"},
+		{"nested", "***both*** and **bold with *italic***", "both and bold with italic"},
+		{"snake_case", "see some_var_name and 2 * 3 * 4", "see some_var_name and 2 * 3 * 4"},
+		{"unclosed", "a **b and `c", "a **b and `c"},
+		{"escaped", `\*not italic\*`, "*not italic*"},
+		{"code span", "run `rm -rf *tmp*` now", "run rm -rf *tmp* now"},
+		{"list", "- one\n  * two\n3. three", "• one\n  ◦ two\n3. three"},
+		{"quote", "> said\n>> before", "│ said\n│ │ before"},
+		{"heading", "## Title ##", "Title"},
+		{"link", "[docs](https://example.com/a)", "docs (https://example.com/a)"},
+		{"bare link", "[https://example.com](https://example.com)", "https://example.com"},
+		{"unsafe link", "[x](javascript:alert(1))", "[x](javascript:alert(1))"},
+		{"code block", "```go\nx := `*a*`\n```\nafter", "┏ go\n┃ x := `*a*`\nafter"},
+		{"unclosed block", "~~~\n**kept**", "┃ **kept**"},
+		{"inline fence", "```not a block```", "not a block"},
+	}
+	for _, tt := range tests {
+		if got := Render(tt.in, Options{Width: 80}); got != tt.want {
+			t.Errorf("%s: Render(%q) = %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRenderWidth(t *testing.T) {
+	in := strings.Repeat("word ", 20) + "\n```\n" + strings.Repeat("x", 40) + "\n```"
+	lines := strings.Split(Render(in, Options{Width: 20}), "\n")
+	for _, line := range lines {
+		if w := lipgloss.Width(line); w > 20 {
+			t.Errorf("line %q is %d wide, want at most 20", line, w)
+		}
+	}
+	if last := lines[len(lines)-1]; !strings.HasSuffix(last, "…") {
+		t.Errorf("long code line %q should be cut off with an ellipsis", last)
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	in := "\x1b]52;c;aGk=\x07red \x1b[31mtext\r\nnext\u202eline\ttab"
+	want := "]52;c;aGk=red [31mtext\nnextline\ttab"
+	if got := Sanitize(in); got != want {
+		t.Errorf("Sanitize = %q, want %q", got, want)
+	}
+	if strings.Contains(Render(in, Options{Width: 80}), "\x1b") {
+		t.Error("Render let an escape sequence through")
+	}
+}
+
+func TestTextHook(t *testing.T) {
+	upper := func(s string) string { return strings.ToUpper(s) }
+	got := Render("hi `code` **there**\n```\nblock\n```", Options{Width: 80, Text: upper})
+	if want := "HI code THERE\n┃ block"; got != want {
+		t.Errorf("Render = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,45 @@
+package qrcode
+
+import (
+	"strings"
+
+	"rsc.io/qr"
+)
+
+const quietZone = 2
+
+// Render encodes text as a QR code drawn with half-block characters, so each
+// terminal row holds two rows of modules. Light modules are drawn with the
+// foreground colour, which scans correctly on dark terminal themes.
+func Render(text string) (string, error) {
+	code, err := qr.Encode(text, qr.L)
+	if err != nil {
+		return "", err
+	}
+
+	light := func(x, y int) bool {
+		if x < 0 || y < 0 || x >= code.Size || y >= code.Size {
+			return true // Quiet zone
+		}
+		return !code.Black(x, y)
+	}
+
+	var s strings.Builder
+	for y := -quietZone; y < code.Size+quietZone; y += 2 {
+		for x := -quietZone; x < code.Size+quietZone; x++ {
+			top, bottom := light(x, y), light(x, y+1)
+			switch {
+			case top && bottom:
+				s.WriteString("█")
+			case top:
+				s.WriteString("▀")
+			case bottom:
+				s.WriteString("▄")
+			default:
+				s.WriteString(" ")
+			}
+		}
+		s.WriteString("\n")
+	}
+	return strings.TrimSuffix(s.String(), "\n"), nil
+}
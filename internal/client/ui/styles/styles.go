@@ -74,6 +74,29 @@ var (
 	OtherMessageStyle = lipgloss.NewStyle().
 				Foreground(PrimaryColor)
 
+	// Timeline events such as "alice added bob"
+	SystemMessageStyle = lipgloss.NewStyle().
+				Foreground(MutedColor).
+				Italic(true)
+
+	// Group role badges
+	OwnerBadgeStyle = lipgloss.NewStyle().
+			Foreground(ActiveBorder).
+			Bold(true)
+	AdminBadgeStyle = lipgloss.NewStyle().
+			Foreground(PrimaryColor)
+
+	// @mentions of the current user
+	MentionStyle = lipgloss.NewStyle().
+			Foreground(BgColor).
+			Background(ActiveBorder).
+			Bold(true)
+
+	// Files shared in messages
+	AttachmentStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#60A5FA")).
+			Bold(true)
+
 	AsciiArt = `
   ██████╗██╗     ██████╗ ███████╗███╗   ███╗███████╗ ██████╗ 
  ██╔════╝██║     ██╔══██╗╚══███╔╝████╗ ████║██╔════╝██╔════╝ 
@@ -0,0 +1,50 @@
+// Package wsconn wraps the client's WebSocket so frames can be sent from
+// any goroutine.
+//
+// gorilla/websocket allows one reader and one writer at a time, but every
+// tea.Cmd runs on its own goroutine, so typing notices, acks and upload
+// chunks can all be sent at once.
+package wsconn
+
+import (
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// Conn is a WebSocket connection whose writes are serialized. Reads still
+// belong to a single goroutine.
+type Conn struct {
+	ws *websocket.Conn
+	mu sync.Mutex
+}
+
+func New(ws *websocket.Conn) *Conn {
+	return &Conn{ws: ws}
+}
+
+// Dial connects to url with dialer.
+func Dial(dialer *websocket.Dialer, url string) (*Conn, error) {
+	ws, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return New(ws), nil
+}
+
+// WriteMessage sends one frame, waiting for any write in progress.
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ws.WriteMessage(messageType, data)
+}
+
+func (c *Conn) ReadMessage() (messageType int, data []byte, err error) {
+	return c.ws.ReadMessage()
+}
+
+// Close closes the connection without waiting for writers; a blocked
+// write fails instead.
+func (c *Conn) Close() error {
+	return c.ws.Close()
+}
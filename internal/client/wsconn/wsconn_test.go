@@ -0,0 +1,69 @@
+package wsconn
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// Upload chunks and acks are sent from different tea.Cmds; without the
+// lock gorilla/websocket panics on the concurrent write (and -race flags it).
+func TestConcurrentChunkAndAck(t *testing.T) {
+	const rounds = 50
+	chunk := bytes.Repeat([]byte("x"), 48*1024)
+	ack := []byte(`{"type":"message_ack","payload":{"message_ids":[1]}}`)
+
+	received := make(chan int, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer ws.Close()
+		n := 0
+		for n < 2*rounds {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				t.Error(err)
+				break
+			}
+			if len(data) != len(chunk) && len(data) != len(ack) {
+				t.Errorf("frame of %d bytes was mangled", len(data))
+			}
+			n++
+		}
+		received <- n
+	}))
+	defer srv.Close()
+
+	conn, err := Dial(websocket.DefaultDialer, "ws"+strings.TrimPrefix(srv.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var wg sync.WaitGroup
+	for _, frame := range [][]byte{chunk, ack} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range rounds {
+				if err := conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := <-received; n != 2*rounds {
+		t.Errorf("server received %d frames, want %d", n, 2*rounds)
+	}
+}
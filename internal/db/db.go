@@ -0,0 +1,118 @@
+// Package db embeds the SQL schema and migrations and applies them.
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+//go:embed schema.sql
+var Schema string
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Migration is one file from internal/db/migrations, applied in name order.
+type Migration struct {
+	Version string // File name without .sql, e.g. "001_totp"
+	SQL     string
+}
+
+func Migrations() ([]Migration, error) {
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	var list []Migration
+	for _, name := range names {
+		data, err := migrations.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		version := strings.TrimSuffix(strings.TrimPrefix(name, "migrations/"), ".sql")
+		list = append(list, Migration{Version: version, SQL: string(data)})
+	}
+	return list, nil
+}
+
+// Migrate brings the database up to date and returns the versions it
+// applied. An empty database gets the full schema, which already contains
+// every migration, so those are only recorded.
+func Migrate(ctx context.Context, conn *sql.DB) ([]string, error) {
+	if _, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version TEXT PRIMARY KEY,
+			applied_at TIMESTAMP DEFAULT NOW()
+		)
+	`); err != nil {
+		return nil, err
+	}
+
+	list, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var hasUsers bool
+	if err := conn.QueryRowContext(ctx, "SELECT to_regclass('users') IS NOT NULL").Scan(&hasUsers); err != nil {
+		return nil, err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if !hasUsers {
+		if _, err := tx.ExecContext(ctx, Schema); err != nil {
+			return nil, fmt.Errorf("apply schema: %w", err)
+		}
+		for _, m := range list {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", m.Version); err != nil {
+				return nil, err
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return []string{"schema"}, nil
+	}
+
+	applied := make(map[string]bool)
+	rows, err := tx.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		applied[v] = true
+	}
+	rows.Close()
+
+	var done []string
+	for _, m := range list {
+		if applied[m.Version] {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+			return nil, fmt.Errorf("migration %s: %w", m.Version, err)
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", m.Version); err != nil {
+			return nil, err
+		}
+		done = append(done, m.Version)
+	}
+	return done, tx.Commit()
+}
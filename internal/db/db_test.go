@@ -0,0 +1,36 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMigrationsOrdered(t *testing.T) {
+	list, err := Migrations()
+	if err != nil {
+		t.Fatalf("Failed to read migrations: %v", err)
+	}
+	if len(list) == 0 {
+		t.Fatal("Expected embedded migrations")
+	}
+	for i := 1; i < len(list); i++ {
+		if list[i-1].Version >= list[i].Version {
+			t.Errorf("Migrations out of order: %s before %s", list[i-1].Version, list[i].Version)
+		}
+	}
+}
+
+// Migrations run against databases that may already have the change
+// (fresh installs get schema.sql), so every statement must be idempotent.
+func TestMigrationsIdempotent(t *testing.T) {
+	list, _ := Migrations()
+	for _, m := range list {
+		for _, stmt := range strings.Split(m.SQL, ";") {
+			upper := strings.ToUpper(stmt)
+			if (strings.Contains(upper, "CREATE TABLE") || strings.Contains(upper, "CREATE INDEX") ||
+				strings.Contains(upper, "ADD COLUMN")) && !strings.Contains(upper, "IF NOT EXISTS") {
+				t.Errorf("%s: statement is not idempotent:%s", m.Version, stmt)
+			}
+		}
+	}
+}
@@ -0,0 +1,2 @@
+-- Allow admins to disable accounts
+ALTER TABLE users ADD COLUMN IF NOT EXISTS disabled BOOLEAN DEFAULT FALSE;
@@ -0,0 +1,19 @@
+-- Per-conversation roles for group management
+ALTER TABLE conversation_participants ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'member';
+
+-- Existing groups have no owner: give it to the earliest participant
+UPDATE conversation_participants cp
+SET role = 'owner'
+FROM (
+    SELECT DISTINCT ON (p.conversation_id) p.conversation_id, p.user_id
+    FROM conversation_participants p
+    JOIN conversations c ON c.id = p.conversation_id
+    WHERE c.is_group
+    ORDER BY p.conversation_id, p.joined_at, p.user_id
+) first
+WHERE cp.conversation_id = first.conversation_id
+  AND cp.user_id = first.user_id
+  AND NOT EXISTS (
+      SELECT 1 FROM conversation_participants o
+      WHERE o.conversation_id = cp.conversation_id AND o.role = 'owner'
+  );
@@ -0,0 +1,2 @@
+-- System events (member added/removed/left, renamed) in the timeline
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS kind TEXT NOT NULL DEFAULT 'text';
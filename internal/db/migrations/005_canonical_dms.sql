@@ -0,0 +1,52 @@
+-- One DM per pair of users: dm_key is "<lower user id>:<higher user id>"
+ALTER TABLE conversations ADD COLUMN IF NOT EXISTS dm_key TEXT;
+
+-- Two-person DMs grouped by pair. Per pair, keep the DM that already has a
+-- key (from an earlier run), otherwise the oldest.
+CREATE OR REPLACE VIEW dm_merge_plan AS
+SELECT conversation_id, pair,
+       FIRST_VALUE(conversation_id) OVER (
+           PARTITION BY pair ORDER BY dm_key IS NULL, conversation_id
+       ) AS keep
+FROM (
+    SELECT cp.conversation_id, MIN(cp.user_id)::text || ':' || MAX(cp.user_id)::text AS pair, c.dm_key
+    FROM conversation_participants cp
+    JOIN conversations c ON c.id = cp.conversation_id
+    WHERE NOT c.is_group
+    GROUP BY cp.conversation_id, c.dm_key
+    HAVING COUNT(*) = 2
+) pairs;
+
+-- Move duplicates' messages into the kept DM
+UPDATE messages m
+SET conversation_id = plan.keep
+FROM dm_merge_plan plan
+WHERE m.conversation_id = plan.conversation_id
+  AND plan.conversation_id <> plan.keep;
+
+-- Keep the earliest join and the latest read position across duplicates
+UPDATE conversation_participants cp
+SET joined_at = LEAST(cp.joined_at, d.joined_at),
+    last_read_at = GREATEST(cp.last_read_at, d.last_read_at)
+FROM (
+    SELECT plan.keep, dup.user_id, MIN(dup.joined_at) AS joined_at, MAX(dup.last_read_at) AS last_read_at
+    FROM dm_merge_plan plan
+    JOIN conversation_participants dup ON dup.conversation_id = plan.conversation_id
+    WHERE plan.conversation_id <> plan.keep
+    GROUP BY plan.keep, dup.user_id
+) d
+WHERE cp.conversation_id = d.keep AND cp.user_id = d.user_id;
+
+DELETE FROM conversations c
+USING dm_merge_plan plan
+WHERE c.id = plan.conversation_id
+  AND plan.conversation_id <> plan.keep;
+
+UPDATE conversations c
+SET dm_key = plan.pair
+FROM dm_merge_plan plan
+WHERE c.id = plan.conversation_id AND c.dm_key IS NULL;
+
+DROP VIEW IF EXISTS dm_merge_plan;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_conversations_dm_key ON conversations(dm_key) WHERE dm_key IS NOT NULL;
@@ -0,0 +1,2 @@
+-- Latest message per conversation for sidebar ordering and previews
+CREATE INDEX IF NOT EXISTS idx_messages_conversation_created ON messages(conversation_id, created_at DESC);
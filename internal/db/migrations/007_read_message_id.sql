@@ -0,0 +1,9 @@
+-- Read position as a message ID, so receipts can name the message
+ALTER TABLE conversation_participants ADD COLUMN IF NOT EXISTS last_read_message_id INT;
+
+UPDATE conversation_participants cp
+SET last_read_message_id = (
+    SELECT MAX(m.id) FROM messages m
+    WHERE m.conversation_id = cp.conversation_id AND m.created_at <= cp.last_read_at
+)
+WHERE cp.last_read_message_id IS NULL;
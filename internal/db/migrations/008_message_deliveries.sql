@@ -0,0 +1,7 @@
+-- Which recipients' devices have received each message
+CREATE TABLE IF NOT EXISTS message_deliveries (
+    message_id INT REFERENCES messages(id) ON DELETE CASCADE,
+    user_id INT REFERENCES users(id) ON DELETE CASCADE,
+    delivered_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (message_id, user_id)
+);
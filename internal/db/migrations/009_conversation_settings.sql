@@ -0,0 +1,5 @@
+-- Per-user conversation settings
+ALTER TABLE conversation_participants ADD COLUMN IF NOT EXISTS muted_until TIMESTAMP;
+ALTER TABLE conversation_participants ADD COLUMN IF NOT EXISTS archived BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE conversation_participants ADD COLUMN IF NOT EXISTS pinned BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE conversation_participants ADD COLUMN IF NOT EXISTS sort_order INT NOT NULL DEFAULT 0;
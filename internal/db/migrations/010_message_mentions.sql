@@ -0,0 +1,7 @@
+-- Participants @mentioned in each message
+CREATE TABLE IF NOT EXISTS message_mentions (
+    message_id INT REFERENCES messages(id) ON DELETE CASCADE,
+    user_id INT REFERENCES users(id) ON DELETE CASCADE,
+    PRIMARY KEY (message_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_message_mentions_user ON message_mentions(user_id);
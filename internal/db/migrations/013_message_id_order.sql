@@ -0,0 +1,3 @@
+-- Messages are ordered by ID, which follows insertion order
+CREATE INDEX IF NOT EXISTS idx_messages_conversation_id ON messages(conversation_id, id DESC);
+DROP INDEX IF EXISTS idx_messages_conversation_created;
@@ -3,14 +3,27 @@ CREATE TABLE users (
     id SERIAL PRIMARY KEY,
     username TEXT UNIQUE NOT NULL,
     password_hash TEXT NOT NULL,
+    totp_secret TEXT,                    -- Set during enrollment, NULL if never enrolled
+    totp_enabled BOOLEAN DEFAULT FALSE,
+    totp_last_step BIGINT DEFAULT 0,     -- Last accepted TOTP step, prevents replay
+    disabled BOOLEAN DEFAULT FALSE,      -- Set by admins, blocks login
     created_at TIMESTAMP DEFAULT NOW()
 );
 
+-- Single-use 2FA recovery codes (hashed)
+CREATE TABLE recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INT REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMP
+);
+
 -- Conversations (DMs and Groups)
 CREATE TABLE conversations (
     id SERIAL PRIMARY KEY,
     name TEXT,                       -- NULL for DMs, set for groups
     is_group BOOLEAN DEFAULT FALSE,
+    dm_key TEXT,                     -- "<lower user id>:<higher user id>" for DMs, NULL for groups
     created_at TIMESTAMP DEFAULT NOW()
 );
 
@@ -20,6 +33,12 @@ CREATE TABLE conversation_participants (
     user_id INT REFERENCES users(id) ON DELETE CASCADE,
     joined_at TIMESTAMP DEFAULT NOW(),
     last_read_at TIMESTAMP DEFAULT NOW(), -- Track read receipts
+    last_read_message_id INT,             -- Newest message read, for "seen by"
+    muted_until TIMESTAMP,                -- No notifications before this time
+    archived BOOLEAN NOT NULL DEFAULT FALSE,
+    pinned BOOLEAN NOT NULL DEFAULT FALSE,
+    sort_order INT NOT NULL DEFAULT 0,    -- Position among pinned conversations
+    role TEXT NOT NULL DEFAULT 'member',  -- owner, admin or member (groups only)
     PRIMARY KEY (conversation_id, user_id)
 );
 
@@ -28,11 +47,47 @@ CREATE TABLE messages (
     id SERIAL PRIMARY KEY,
     conversation_id INT REFERENCES conversations(id) ON DELETE CASCADE,
     sender_id INT REFERENCES users(id) ON DELETE SET NULL,
+    kind TEXT NOT NULL DEFAULT 'text',   -- text, or a system event such as member_added
     content TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    client_id TEXT                       -- Sender's ID for the message, makes resends idempotent
+);
+
+-- Which recipients' devices have received each message
+CREATE TABLE message_deliveries (
+    message_id INT REFERENCES messages(id) ON DELETE CASCADE,
+    user_id INT REFERENCES users(id) ON DELETE CASCADE,
+    delivered_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (message_id, user_id)
+);
+
+CREATE TABLE message_mentions (
+    message_id INT REFERENCES messages(id) ON DELETE CASCADE,
+    user_id INT REFERENCES users(id) ON DELETE CASCADE,
+    PRIMARY KEY (message_id, user_id)
+);
+
+-- Files shared in messages; the data is kept in the attachments directory
+CREATE TABLE attachments (
+    id SERIAL PRIMARY KEY,
+    message_id INT REFERENCES messages(id) ON DELETE CASCADE,
+    uploader_id INT REFERENCES users(id) ON DELETE SET NULL, -- Charged to their quota
+    name TEXT NOT NULL,
+    mime_type TEXT NOT NULL,             -- Detected from the content
+    size BIGINT NOT NULL,
+    sha256 TEXT NOT NULL,
+    storage_key TEXT UNIQUE NOT NULL,    -- File name on disk
     created_at TIMESTAMP DEFAULT NOW()
 );
 
 -- Indexes for performance
 CREATE INDEX idx_messages_conversation ON messages(conversation_id);
 CREATE INDEX idx_messages_created ON messages(created_at);
+CREATE INDEX idx_messages_conversation_id ON messages(conversation_id, id DESC);
 CREATE INDEX idx_participants_user ON conversation_participants(user_id);
+CREATE INDEX idx_recovery_codes_user ON recovery_codes(user_id);
+CREATE INDEX idx_message_mentions_user ON message_mentions(user_id);
+CREATE INDEX idx_attachments_message ON attachments(message_id);
+CREATE INDEX idx_attachments_uploader ON attachments(uploader_id);
+CREATE UNIQUE INDEX idx_messages_client_id ON messages(sender_id, client_id) WHERE client_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_conversations_dm_key ON conversations(dm_key) WHERE dm_key IS NOT NULL;
@@ -0,0 +1,262 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudzz-dev/cldzmsg/internal/server/models"
+	"github.com/cloudzz-dev/cldzmsg/internal/server/ratelimit"
+	"github.com/cloudzz-dev/cldzmsg/internal/server/storage"
+	"github.com/cloudzz-dev/cldzmsg/internal/server/ws"
+)
+
+const Prefix = "/admin/api"
+
+// API is the operator HTTP API. Every request needs
+// "Authorization: Bearer <token>"; with no token configured the API is off.
+type API struct {
+	Store   *storage.Store
+	Hub     *ws.Hub
+	Limiter *ratelimit.RateLimiter
+
+	mu    sync.RWMutex
+	token string
+}
+
+func New(store *storage.Store, hub *ws.Hub, limiter *ratelimit.RateLimiter, token string) *API {
+	return &API{Store: store, Hub: hub, Limiter: limiter, token: token}
+}
+
+// SetToken swaps the bearer token, e.g. on config reload.
+func (a *API) SetToken(token string) {
+	a.mu.Lock()
+	a.token = token
+	a.mu.Unlock()
+}
+
+func (a *API) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+Prefix+"/users", a.listUsers)
+	mux.HandleFunc("POST "+Prefix+"/users/{id}/disable", a.setDisabled(true))
+	mux.HandleFunc("POST "+Prefix+"/users/{id}/enable", a.setDisabled(false))
+	mux.HandleFunc("POST "+Prefix+"/users/{id}/logout", a.logoutUser)
+	mux.HandleFunc("DELETE "+Prefix+"/messages/{id}", a.deleteMessage)
+	mux.HandleFunc("GET "+Prefix+"/conversations/{id}", a.getConversation)
+	mux.HandleFunc("GET "+Prefix+"/ratelimit", a.rateLimit)
+	return a.authenticate(mux)
+}
+
+func (a *API) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.mu.RLock()
+		token := a.token
+		a.mu.RUnlock()
+
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			slog.Warn("Rejected admin request", "path", r.URL.Path, "ip", ratelimit.GetClientIP(r))
+			w.Header().Set("WWW-Authenticate", `Bearer realm="cldzmsg-admin"`)
+			writeError(w, http.StatusUnauthorized, "invalid or missing bearer token")
+			return
+		}
+
+		slog.Info("Admin request", "method", r.Method, "path", r.URL.Path, "ip", ratelimit.GetClientIP(r))
+		next.ServeHTTP(w, r)
+	})
+}
+
+type userView struct {
+	ID          int       `json:"id"`
+	Username    string    `json:"username"`
+	Disabled    bool      `json:"disabled"`
+	TOTPEnabled bool      `json:"totp_enabled"`
+	Online      bool      `json:"online"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (a *API) listUsers(w http.ResponseWriter, r *http.Request) {
+	limit := queryInt(r, "limit", 50)
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+	offset := queryInt(r, "offset", 0)
+
+	users, err := a.Store.SearchUsers(r.URL.Query().Get("q"), limit, offset)
+	if err != nil {
+		serverError(w, "search users", err)
+		return
+	}
+
+	online := a.Hub.OnlineUsers()
+	views := make([]userView, 0, len(users))
+	for _, u := range users {
+		views = append(views, userView{
+			ID:          u.ID,
+			Username:    u.Username,
+			Disabled:    u.Disabled,
+			TOTPEnabled: u.TOTPEnabled,
+			Online:      online[u.ID],
+			CreatedAt:   u.CreatedAt,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"users": views})
+}
+
+func (a *API) setDisabled(disabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+		if err := a.Store.SetUserDisabled(id, disabled); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				writeError(w, http.StatusNotFound, "user not found")
+				return
+			}
+			serverError(w, "set user disabled", err)
+			return
+		}
+
+		closed := 0
+		if disabled {
+			closed = a.Hub.Disconnect(id, "Your account has been disabled")
+		}
+		slog.Info("Admin changed account state", "user_id", id, "disabled", disabled, "connections_closed", closed)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"user_id":            id,
+			"disabled":           disabled,
+			"connections_closed": closed,
+		})
+	}
+}
+
+func (a *API) logoutUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	closed := a.Hub.Disconnect(id, "You were logged out by an administrator")
+	slog.Info("Admin forced logout", "user_id", id, "connections_closed", closed)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"user_id":            id,
+		"connections_closed": closed,
+	})
+}
+
+func (a *API) deleteMessage(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	msg, err := a.Store.DeleteMessage(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "message not found")
+			return
+		}
+		serverError(w, "delete message", err)
+		return
+	}
+
+	if msg.Attachment != nil && a.Hub.Files != nil {
+		if err := a.Hub.Files.Remove(msg.Attachment.StorageKey); err != nil {
+			slog.Error("Failed to remove attachment of deleted message", "message_id", msg.ID, "error", err)
+		}
+	}
+
+	ids, err := a.Store.GetParticipantIDs(msg.ConversationID)
+	if err != nil {
+		slog.Error("Failed to load participants", "conversation_id", msg.ConversationID, "error", err)
+	}
+	frame, _ := json.Marshal(map[string]interface{}{
+		"type":            "message_deleted",
+		"message_id":      msg.ID,
+		"conversation_id": msg.ConversationID,
+	})
+	a.Hub.SendToUsers(ids, frame)
+
+	slog.Info("Admin deleted message", "message_id", msg.ID, "conversation_id", msg.ConversationID)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"deleted": msg})
+}
+
+func (a *API) getConversation(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	conv, count, err := a.Store.GetConversation(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "conversation not found")
+			return
+		}
+		serverError(w, "get conversation", err)
+		return
+	}
+	participants, err := a.Store.GetParticipants(id)
+	if err != nil {
+		serverError(w, "get participants", err)
+		return
+	}
+	if participants == nil {
+		participants = []models.Participant{}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"conversation":  conv,
+		"message_count": count,
+		"participants":  participants,
+	})
+}
+
+func (a *API) rateLimit(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.Limiter.Snapshot())
+}
+
+// Helpers
+
+func pathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		writeError(w, http.StatusBadRequest, "invalid id")
+		return 0, false
+	}
+	return id, true
+}
+
+func queryInt(r *http.Request, key string, def int) int {
+	if v := r.URL.Query().Get(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+func serverError(w http.ResponseWriter, op string, err error) {
+	slog.Error("Admin API error", "op", op, "error", err)
+	writeError(w, http.StatusInternalServerError, "internal error")
+}
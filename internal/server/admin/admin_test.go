@@ -0,0 +1,49 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudzz-dev/cldzmsg/internal/server/ratelimit"
+)
+
+func TestAuthentication(t *testing.T) {
+	api := New(nil, nil, ratelimit.New(10, 5), "")
+
+	tests := []struct {
+		token  string
+		header string
+		want   int
+	}{
+		{"", "Bearer anything", http.StatusNotFound}, // API disabled
+		{"s3cret-token-value", "", http.StatusUnauthorized},
+		{"s3cret-token-value", "Bearer wrong", http.StatusUnauthorized},
+		{"s3cret-token-value", "s3cret-token-value", http.StatusUnauthorized},
+		{"s3cret-token-value", "Bearer s3cret-token-value", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		api.SetToken(tt.token)
+		req := httptest.NewRequest("GET", Prefix+"/ratelimit", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		rec := httptest.NewRecorder()
+		api.Handler().ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("token=%q header=%q: got %d, want %d", tt.token, tt.header, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestInvalidID(t *testing.T) {
+	api := New(nil, nil, ratelimit.New(10, 5), "s3cret-token-value")
+	req := httptest.NewRequest("POST", Prefix+"/users/abc/logout", nil)
+	req.Header.Set("Authorization", "Bearer s3cret-token-value")
+	rec := httptest.NewRecorder()
+	api.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("got %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,297 @@
+// Package attachments keeps uploaded files on the local filesystem and
+// enforces the size limit and quotas for new uploads.
+//
+// Files are written to a temporary name while they arrive in chunks and
+// moved to <dir>/<key[:2]>/<key> once complete; the key is random and the
+// database holds everything else about the file.
+package attachments
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	ErrEmpty     = errors.New("file is empty")
+	ErrBadOffset = errors.New("chunk does not continue the upload")
+	ErrOverflow  = errors.New("more data than the announced size")
+)
+
+// Limits bounds uploads, in bytes. Zero quotas are unlimited.
+type Limits struct {
+	MaxFileSize int64
+	UserQuota   int64 // Total size of one user's uploads
+	TotalQuota  int64 // Total size of all stored files
+}
+
+// Store is a directory of uploaded files. In-progress uploads reserve
+// their size, so concurrent ones can't overrun a quota together.
+type Store struct {
+	dir string
+
+	mu       sync.Mutex
+	limits   Limits
+	reserved map[int]int64 // userID -> bytes of unfinished uploads
+	total    int64         // Sum of reserved
+}
+
+// New opens dir, creating it if needed, and clears temporary files left
+// by uploads that were cut off.
+func New(dir string, limits Limits) (*Store, error) {
+	tmp := filepath.Join(dir, "tmp")
+	if err := os.MkdirAll(tmp, 0700); err != nil {
+		return nil, fmt.Errorf("attachments: %w", err)
+	}
+	entries, err := os.ReadDir(tmp)
+	if err != nil {
+		return nil, fmt.Errorf("attachments: %w", err)
+	}
+	for _, e := range entries {
+		if isKey(e.Name()) {
+			os.Remove(filepath.Join(tmp, e.Name()))
+		}
+	}
+	return &Store{dir: dir, limits: limits, reserved: make(map[int]int64)}, nil
+}
+
+// SetLimits changes the limits for uploads started from now on.
+func (s *Store) SetLimits(l Limits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limits = l
+}
+
+func (s *Store) Limits() Limits {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limits
+}
+
+// Begin starts an upload of size bytes by userID, given how much the user
+// and everyone have stored already. Errors are meant for the user.
+func (s *Store) Begin(userID int, size, userUsed, totalUsed int64) (*Upload, error) {
+	if size <= 0 {
+		return nil, ErrEmpty
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.limits
+	switch {
+	case size > l.MaxFileSize:
+		return nil, fmt.Errorf("file is larger than the %s limit", FormatSize(l.MaxFileSize))
+	case l.UserQuota > 0 && userUsed+s.reserved[userID]+size > l.UserQuota:
+		return nil, fmt.Errorf("this would exceed your %s storage quota", FormatSize(l.UserQuota))
+	case l.TotalQuota > 0 && totalUsed+s.total+size > l.TotalQuota:
+		return nil, errors.New("the server is out of space for attachments")
+	}
+
+	id, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, "tmp", id), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	s.reserved[userID] += size
+	s.total += size
+	return &Upload{ID: id, Size: size, store: s, userID: userID, file: f, hash: sha256.New()}, nil
+}
+
+func (s *Store) release(userID int, size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reserved[userID] -= size
+	if s.reserved[userID] <= 0 {
+		delete(s.reserved, userID)
+	}
+	s.total -= size
+}
+
+// Open opens a stored file for reading.
+func (s *Store) Open(key string) (*os.File, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// Remove deletes a stored file.
+func (s *Store) Remove(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Prune deletes stored files whose key keep doesn't know, such as those of
+// deleted messages, and returns how many it removed. Files not named like
+// a key are never touched.
+func (s *Store) Prune(keep func(key string) bool) (int, error) {
+	removed := 0
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != s.dir && d.Name() == "tmp" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isKey(d.Name()) || keep(d.Name()) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
+
+// path maps a key to its file, rejecting anything that isn't a key so a
+// bad value can't point outside the directory.
+func (s *Store) path(key string) (string, error) {
+	if !isKey(key) {
+		return "", fmt.Errorf("attachments: invalid key %q", key)
+	}
+	return filepath.Join(s.dir, key[:2], key), nil
+}
+
+func isKey(s string) bool {
+	return len(s) == 32 && strings.Trim(s, "0123456789abcdef") == ""
+}
+
+func newKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Upload is a file arriving in chunks. Close must be called once it is
+// finished or abandoned.
+type Upload struct {
+	ID       string
+	Size     int64
+	Received int64
+
+	store  *Store
+	userID int
+	file   *os.File
+	hash   hash.Hash
+	head   []byte // Start of the file, to detect its type
+	closed bool
+}
+
+// Write appends a chunk, which must start where the last one ended.
+func (u *Upload) Write(offset int64, data []byte) error {
+	if offset != u.Received {
+		return ErrBadOffset
+	}
+	if u.Received+int64(len(data)) > u.Size {
+		return ErrOverflow
+	}
+	if _, err := u.file.Write(data); err != nil {
+		return err
+	}
+	u.hash.Write(data)
+	if len(u.head) < 512 {
+		u.head = append(u.head, data[:min(len(data), 512-len(u.head))]...)
+	}
+	u.Received += int64(len(data))
+	return nil
+}
+
+// Done reports whether all of the file has arrived.
+func (u *Upload) Done() bool {
+	return u.Received == u.Size
+}
+
+// MimeType is the type detected from the file's content.
+func (u *Upload) MimeType() string {
+	return http.DetectContentType(u.head)
+}
+
+// Finish moves the complete file into the store and returns its key and
+// SHA-256 checksum.
+func (u *Upload) Finish() (key, sum string, err error) {
+	if !u.Done() {
+		return "", "", fmt.Errorf("attachments: upload incomplete, %d of %d bytes", u.Received, u.Size)
+	}
+	if err := u.file.Close(); err != nil {
+		return "", "", err
+	}
+	key = u.ID
+	path, _ := u.store.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", "", err
+	}
+	if err := os.Rename(u.file.Name(), path); err != nil {
+		return "", "", err
+	}
+	return key, hex.EncodeToString(u.hash.Sum(nil)), nil
+}
+
+// Close releases the upload's reservation and deletes what it wrote unless
+// it was finished.
+func (u *Upload) Close() {
+	if u.closed {
+		return
+	}
+	u.closed = true
+	u.file.Close()
+	os.Remove(u.file.Name()) // Already gone if finished
+	u.store.release(u.userID, u.Size)
+}
+
+// CleanName makes a client-supplied file name safe to store and show: the
+// last path element, without control characters, at most 255 bytes.
+func CleanName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(filepath.Base(strings.ReplaceAll(name, "\\", "/")))
+	for len(name) > 255 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	if name == "" || name == "." || name == ".." || name == "/" {
+		return "file"
+	}
+	return name
+}
+
+// FormatSize renders a byte count as B, KB, MB or GB.
+func FormatSize(n int64) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%d B", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.1f KB", float64(n)/1024)
+	case n < 1024*1024*1024:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1024*1024))
+	}
+	return fmt.Sprintf("%.1f GB", float64(n)/(1024*1024*1024))
+}
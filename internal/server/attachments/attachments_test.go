@@ -0,0 +1,156 @@
+package attachments
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newStore(t *testing.T, limits Limits) *Store {
+	t.Helper()
+	s, err := New(t.TempDir(), limits)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return s
+}
+
+func TestUpload(t *testing.T) {
+	s := newStore(t, Limits{MaxFileSize: 100})
+	up, err := s.Begin(1, 11, 0, 0)
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	defer up.Close()
+
+	if err := up.Write(0, []byte("hello ")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := up.Write(0, []byte("again")); !errors.Is(err, ErrBadOffset) {
+		t.Errorf("Rewriting offset 0 = %v, want ErrBadOffset", err)
+	}
+	if err := up.Write(6, []byte("world!")); !errors.Is(err, ErrOverflow) {
+		t.Errorf("Writing past the size = %v, want ErrOverflow", err)
+	}
+	if _, _, err := up.Finish(); err == nil {
+		t.Error("Finish should fail before all data has arrived")
+	}
+	if err := up.Write(6, []byte("world")); err != nil || !up.Done() {
+		t.Fatalf("Write = %v, done = %v", err, up.Done())
+	}
+
+	key, sum, err := up.Finish()
+	if err != nil {
+		t.Fatalf("Finish: %v", err)
+	}
+	if sum != "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9" {
+		t.Errorf("Unexpected checksum %s", sum)
+	}
+	if got := up.MimeType(); !strings.HasPrefix(got, "text/plain") {
+		t.Errorf("MimeType = %q", got)
+	}
+
+	f, err := s.Open(key)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	data, _ := io.ReadAll(f)
+	f.Close()
+	if string(data) != "hello world" {
+		t.Errorf("Stored %q", data)
+	}
+
+	if _, err := s.Open("../../etc/passwd"); err == nil {
+		t.Error("Open should reject something that isn't a key")
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	s := newStore(t, Limits{MaxFileSize: 50, UserQuota: 80, TotalQuota: 120})
+
+	if _, err := s.Begin(1, 0, 0, 0); !errors.Is(err, ErrEmpty) {
+		t.Errorf("Empty file = %v, want ErrEmpty", err)
+	}
+	if _, err := s.Begin(1, 51, 0, 0); err == nil {
+		t.Error("File over the size limit was accepted")
+	}
+
+	// Unfinished uploads count towards the quotas
+	first, err := s.Begin(1, 50, 0, 0)
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	if _, err := s.Begin(1, 40, 0, 0); err == nil {
+		t.Error("Second upload should exceed the user quota")
+	}
+	if _, err := s.Begin(2, 40, 0, 100); err == nil {
+		t.Error("Upload should exceed the total quota")
+	}
+
+	first.Close()
+	up, err := s.Begin(1, 40, 0, 0)
+	if err != nil {
+		t.Fatalf("Closing should release the reservation: %v", err)
+	}
+	up.Close()
+	if entries, _ := os.ReadDir(filepath.Join(s.dir, "tmp")); len(entries) != 0 {
+		t.Errorf("Closed uploads left %d temporary files", len(entries))
+	}
+}
+
+func TestPrune(t *testing.T) {
+	s := newStore(t, Limits{MaxFileSize: 10})
+	var keys []string
+	for range 2 {
+		up, err := s.Begin(1, 1, 0, 0)
+		if err != nil {
+			t.Fatalf("Begin: %v", err)
+		}
+		up.Write(0, []byte("x"))
+		key, _, err := up.Finish()
+		if err != nil {
+			t.Fatalf("Finish: %v", err)
+		}
+		up.Close()
+		keys = append(keys, key)
+	}
+
+	// Not a key, so not ours
+	other := filepath.Join(s.dir, "notes.txt")
+	os.WriteFile(other, []byte("keep me"), 0600)
+
+	removed, err := s.Prune(func(key string) bool { return key == keys[0] })
+	if err != nil || removed != 1 {
+		t.Fatalf("Prune = %d, %v; want 1 removed", removed, err)
+	}
+	if _, err := s.Open(keys[0]); err != nil {
+		t.Errorf("Kept file is gone: %v", err)
+	}
+	if _, err := s.Open(keys[1]); err == nil {
+		t.Error("Pruned file is still there")
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("Prune removed a file that isn't an attachment: %v", err)
+	}
+}
+
+func TestCleanName(t *testing.T) {
+	for in, want := range map[string]string{
+		"report.log":           "report.log",
+		"../../etc/passwd":     "passwd",
+		`C:\Users\me\shot.png`: "shot.png",
+		"bad\x1b[31mname\n":    "bad[31mname",
+		"..":                   "file",
+		"":                     "file",
+	} {
+		if got := CleanName(in); got != want {
+			t.Errorf("CleanName(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if got := CleanName(strings.Repeat("é", 200)); len(got) > 255 || !strings.HasPrefix(got, "é") {
+		t.Errorf("Long name not cut to 255 bytes on a rune boundary: %d bytes", len(got))
+	}
+}
@@ -0,0 +1,156 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/cloudzz-dev/cldzmsg/internal/server/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrInvalidHash = errors.New("invalid password hash")
+
+// Argon2Params are the argon2id cost parameters. Memory is in KiB.
+type Argon2Params struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// DefaultArgon2Params follow the OWASP baseline for argon2id.
+var DefaultArgon2Params = Argon2Params{
+	Memory:  64 * 1024,
+	Time:    3,
+	Threads: 2,
+	SaltLen: 16,
+	KeyLen:  32,
+}
+
+// PasswordHasher hashes new passwords with argon2id, encoded as PHC strings,
+// and still verifies legacy bcrypt hashes so they can be upgraded on login.
+type PasswordHasher struct {
+	Params Argon2Params
+
+	dummyOnce sync.Once
+	dummy     string
+}
+
+func NewPasswordHasher(params Argon2Params) *PasswordHasher {
+	return &PasswordHasher{Params: params}
+}
+
+// NewPasswordHasherFromConfig uses the configured cost, with the default
+// salt and key lengths.
+func NewPasswordHasherFromConfig(cfg config.Argon2Config) *PasswordHasher {
+	params := DefaultArgon2Params
+	params.Memory = cfg.MemoryKiB
+	params.Time = cfg.Time
+	params.Threads = cfg.Threads
+	return NewPasswordHasher(params)
+}
+
+// Hash returns a PHC string such as
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	p := h.Params
+	salt := make([]byte, p.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify reports whether password matches encoded, which may be an argon2id
+// PHC string or a legacy bcrypt hash.
+func (h *PasswordHasher) Verify(password, encoded string) (bool, error) {
+	if isBcrypt(encoded) {
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+// VerifyDummy checks password against a throwaway hash, so a login naming
+// an unknown user takes as long as one with a wrong password.
+func (h *PasswordHasher) VerifyDummy(password string) {
+	h.dummyOnce.Do(func() {
+		h.dummy, _ = h.Hash("dummy password")
+	})
+	h.Verify(password, h.dummy)
+}
+
+// NeedsRehash reports whether encoded should be replaced with a fresh hash,
+// either because it is bcrypt or because the argon2id parameters changed.
+func (h *PasswordHasher) NeedsRehash(encoded string) bool {
+	if isBcrypt(encoded) {
+		return true
+	}
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Memory != h.Params.Memory ||
+		params.Time != h.Params.Time ||
+		params.Threads != h.Params.Threads ||
+		uint32(len(salt)) != h.Params.SaltLen ||
+		uint32(len(key)) != h.Params.KeyLen
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") ||
+		strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
+
+func decodeArgon2id(encoded string) (Argon2Params, []byte, []byte, error) {
+	var p Argon2Params
+
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, key
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, ErrInvalidHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, ErrInvalidHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, ErrInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, ErrInvalidHash
+	}
+	p.SaltLen = uint32(len(salt))
+	p.KeyLen = uint32(len(key))
+	return p, salt, key, nil
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Cheap parameters keep the tests fast
+var testParams = Argon2Params{Memory: 1024, Time: 1, Threads: 1, SaltLen: 16, KeyLen: 32}
+
+func TestHashVerify(t *testing.T) {
+	h := &PasswordHasher{Params: testParams}
+
+	encoded, err := h.Hash("hunter2")
+	if err != nil {
+		t.Fatalf("Failed to hash: %v", err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("Unexpected PHC string: %s", encoded)
+	}
+
+	if ok, err := h.Verify("hunter2", encoded); err != nil || !ok {
+		t.Errorf("Expected password to verify, got ok=%v err=%v", ok, err)
+	}
+	if ok, _ := h.Verify("hunter3", encoded); ok {
+		t.Error("Expected wrong password to fail")
+	}
+	if h.NeedsRehash(encoded) {
+		t.Error("Fresh hash should not need rehash")
+	}
+
+	// Raising the cost should flag existing hashes for upgrade
+	stronger := &PasswordHasher{Params: testParams}
+	stronger.Params.Time = 2
+	if !stronger.NeedsRehash(encoded) {
+		t.Error("Expected hash with old parameters to need rehash")
+	}
+	if ok, _ := stronger.Verify("hunter2", encoded); !ok {
+		t.Error("Old hashes must still verify after a parameter change")
+	}
+}
+
+func TestVerifyLegacyBcrypt(t *testing.T) {
+	h := &PasswordHasher{Params: testParams}
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Failed to create bcrypt hash: %v", err)
+	}
+
+	if ok, err := h.Verify("hunter2", string(legacy)); err != nil || !ok {
+		t.Errorf("Expected bcrypt hash to verify, got ok=%v err=%v", ok, err)
+	}
+	if ok, err := h.Verify("nope", string(legacy)); err != nil || ok {
+		t.Errorf("Expected mismatch without error, got ok=%v err=%v", ok, err)
+	}
+	if !h.NeedsRehash(string(legacy)) {
+		t.Error("Expected bcrypt hash to need rehash")
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	h := &PasswordHasher{Params: testParams}
+	for _, bad := range []string{"", "plain", "$argon2id$v=19$m=1,t=1$abc$def", "$argon2i$v=19$m=1,t=1,p=1$YQ$YQ"} {
+		if ok, err := h.Verify("x", bad); ok || err == nil {
+			t.Errorf("Verify(%q) = %v, %v; want false with error", bad, ok, err)
+		}
+	}
+}
+
+func TestVerifyDummy(t *testing.T) {
+	h := &PasswordHasher{Params: testParams}
+	h.VerifyDummy("hunter2")
+	// The dummy must be a real hash, or unknown users would fail faster
+	if _, _, _, err := decodeArgon2id(h.dummy); err != nil {
+		t.Errorf("Dummy hash %q is not argon2id: %v", h.dummy, err)
+	}
+}
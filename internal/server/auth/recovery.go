@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+)
+
+// recoveryEncoding uses an alphabet that avoids characters that are easy to misread (0/o, 1/l).
+var recoveryEncoding = base32.NewEncoding("abcdefghijkmnpqrstuvwxyz23456789").WithPadding(base32.NoPadding)
+
+// GenerateRecoveryCodes returns n single-use codes formatted as "xxxxx-xxxxx".
+// Callers must only ever persist HashRecoveryCode of these.
+func GenerateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		raw := recoveryEncoding.EncodeToString(buf)[:10]
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode makes user input comparable with a generated code.
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, " ", "")
+	code = strings.ReplaceAll(code, "-", "")
+	if len(code) != 10 {
+		return code
+	}
+	return code[:5] + "-" + code[5:]
+}
+
+// IsRecoveryCode reports whether input looks like a recovery code rather
+// than a numeric TOTP code.
+func IsRecoveryCode(code string) bool {
+	return len(NormalizeRecoveryCode(code)) == 11
+}
+
+// HashRecoveryCode returns the SHA-256 of a normalized code, hex-encoded.
+// The codes are random, so unlike passwords they need no slow hash, and
+// checking one costs an attacker's login attempt next to nothing.
+func HashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// MatchRecoveryCode compares a normalized code with a stored hash in
+// constant time.
+func MatchRecoveryCode(code, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashRecoveryCode(code)), []byte(hash)) == 1
+}
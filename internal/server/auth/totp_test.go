@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B test secret ("12345678901234567890" in ASCII)
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func fixedClock(unix int64) func() time.Time {
+	return func() time.Time { return time.Unix(unix, 0) }
+}
+
+func TestCodeRFCVectors(t *testing.T) {
+	totp := NewTOTP()
+	totp.Digits = 8
+
+	vectors := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+	for unix, want := range vectors {
+		got, err := totp.Code(rfcSecret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatalf("Code(%d) failed: %v", unix, err)
+		}
+		if got != want {
+			t.Errorf("Code(%d) = %s, want %s", unix, got, want)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	totp := NewTOTP()
+	totp.Now = fixedClock(1111111109)
+
+	// Six digit code is the last six digits of the RFC vector
+	step, ok := totp.Verify(rfcSecret, "081804", 0)
+	if !ok {
+		t.Fatal("Expected current code to verify")
+	}
+
+	// Replaying the same code must fail once its step is recorded
+	if _, ok := totp.Verify(rfcSecret, "081804", step); ok {
+		t.Error("Expected replayed code to be rejected")
+	}
+
+	// One period of drift is tolerated, two are not
+	prev, _ := totp.Code(rfcSecret, time.Unix(1111111109-30, 0))
+	if _, ok := totp.Verify(rfcSecret, prev, 0); !ok {
+		t.Error("Expected code from previous period to verify")
+	}
+	old, _ := totp.Code(rfcSecret, time.Unix(1111111109-90, 0))
+	if _, ok := totp.Verify(rfcSecret, old, 0); ok {
+		t.Error("Expected code from three periods ago to be rejected")
+	}
+
+	if _, ok := totp.Verify(rfcSecret, "000000", 0); ok {
+		t.Error("Expected wrong code to be rejected")
+	}
+}
+
+func TestURI(t *testing.T) {
+	totp := NewTOTP()
+	uri := totp.URI("cldzmsg", "alice", "JBSWY3DPEHPK3PXP")
+	if !strings.HasPrefix(uri, "otpauth://totp/cldzmsg:alice?") {
+		t.Errorf("Unexpected URI prefix: %s", uri)
+	}
+	if !strings.Contains(uri, "secret=JBSWY3DPEHPK3PXP") {
+		t.Errorf("URI missing secret: %s", uri)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("Failed to generate codes: %v", err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("Expected 10 codes, got %d", len(codes))
+	}
+	seen := make(map[string]bool)
+	for _, c := range codes {
+		if !IsRecoveryCode(c) {
+			t.Errorf("Generated code %q not recognised", c)
+		}
+		if seen[c] {
+			t.Errorf("Duplicate code %q", c)
+		}
+		seen[c] = true
+	}
+
+	if got := NormalizeRecoveryCode(" ABCDE fghij "); got != "abcde-fghij" {
+		t.Errorf("NormalizeRecoveryCode = %q", got)
+	}
+	if IsRecoveryCode("123456") {
+		t.Error("TOTP code treated as recovery code")
+	}
+
+	hash := HashRecoveryCode(codes[0])
+	if !MatchRecoveryCode(NormalizeRecoveryCode(strings.ToUpper(codes[0])), hash) {
+		t.Error("Recovery code does not match its hash")
+	}
+	if MatchRecoveryCode(codes[1], hash) {
+		t.Error("Different recovery code matched")
+	}
+}
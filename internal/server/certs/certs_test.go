@@ -0,0 +1,61 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writePair(t *testing.T, dir string) []byte {
+	t.Helper()
+	cert, err := SelfSigned([]string{"localhost", "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	os.WriteFile(filepath.Join(dir, "cert.pem"), certPEM, 0600)
+	os.WriteFile(filepath.Join(dir, "key.pem"), keyPEM, 0600)
+	return cert.Certificate[0]
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	first := writePair(t, dir)
+
+	r, err := NewReloader(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+	if err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+	got, _ := r.GetCertificate(nil)
+	if !bytes.Equal(got.Certificate[0], first) {
+		t.Fatal("Expected first certificate to be served")
+	}
+
+	second := writePair(t, dir)
+	if err := r.Reload(); err != nil {
+		t.Fatalf("Failed to reload: %v", err)
+	}
+	got, _ = r.GetCertificate(nil)
+	if !bytes.Equal(got.Certificate[0], second) {
+		t.Fatal("Expected renewed certificate after reload")
+	}
+
+	// A broken file must not take down the certificate in use
+	os.WriteFile(filepath.Join(dir, "cert.pem"), []byte("garbage"), 0600)
+	if err := r.Reload(); err == nil {
+		t.Fatal("Expected reload of invalid certificate to fail")
+	}
+	got, _ = r.GetCertificate(nil)
+	if !bytes.Equal(got.Certificate[0], second) {
+		t.Fatal("Expected previous certificate to remain after failed reload")
+	}
+}
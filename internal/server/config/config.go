@@ -0,0 +1,281 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every server setting. Values come from defaults, then the
+// YAML file, then environment variables, in that order of precedence.
+type Config struct {
+	Port           string            `yaml:"port"`
+	HealthPort     string            `yaml:"health_port"` // Plain HTTP /livez, /readyz and /metrics; empty disables metrics
+	DatabaseURL    string            `yaml:"database_url"`
+	LogLevel       string            `yaml:"log_level"`
+	AllowedOrigins []string          `yaml:"allowed_origins"`
+	RateLimit      RateLimitConfig   `yaml:"rate_limit"`
+	TLS            TLSConfig         `yaml:"tls"`
+	Argon2         Argon2Config      `yaml:"argon2"`
+	Admin          AdminConfig       `yaml:"admin"`
+	Attachments    AttachmentsConfig `yaml:"attachments"`
+}
+
+type RateLimitConfig struct {
+	MaxConnectionsPerIP int `yaml:"max_connections_per_ip"`
+	AuthAttemptsPerMin  int `yaml:"auth_attempts_per_min"`
+}
+
+type TLSConfig struct {
+	CertFile   string   `yaml:"cert_file"`
+	KeyFile    string   `yaml:"key_file"`
+	SelfSigned bool     `yaml:"self_signed"`
+	Hosts      []string `yaml:"hosts"`
+}
+
+type AdminConfig struct {
+	Token string `yaml:"token"` // Bearer token for /admin/api; empty disables it
+}
+
+// AttachmentsConfig sets where uploaded files are kept and how much may be
+// stored. Sizes are in MiB; zero quotas are unlimited.
+type AttachmentsConfig struct {
+	Dir          string `yaml:"dir"`
+	MaxFileMB    int    `yaml:"max_file_mb"`
+	UserQuotaMB  int    `yaml:"user_quota_mb"`
+	TotalQuotaMB int    `yaml:"total_quota_mb"`
+}
+
+type Argon2Config struct {
+	MemoryKiB uint32 `yaml:"memory_kib"`
+	Time      uint32 `yaml:"time"`
+	Threads   uint8  `yaml:"threads"`
+}
+
+func Default() *Config {
+	return &Config{
+		Port:        "3567",
+		DatabaseURL: "postgres://localhost/cldzmsg?sslmode=disable",
+		LogLevel:    "info",
+		RateLimit: RateLimitConfig{
+			MaxConnectionsPerIP: 10,
+			AuthAttemptsPerMin:  5,
+		},
+		TLS: TLSConfig{
+			Hosts: []string{"localhost", "127.0.0.1", "::1"},
+		},
+		Argon2: Argon2Config{
+			MemoryKiB: 64 * 1024,
+			Time:      3,
+			Threads:   2,
+		},
+		Attachments: AttachmentsConfig{
+			Dir:         "attachments",
+			MaxFileMB:   25,
+			UserQuotaMB: 500,
+		},
+	}
+}
+
+// Load builds the configuration from path (optional) and the environment,
+// and validates it. All problems are reported together.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("config: %w", err)
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true) // Catch typos instead of silently ignoring them
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("config: %s: %w", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) applyEnv() error {
+	var errs []error
+
+	str := func(name string, dst *string) {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			*dst = v
+		}
+	}
+	list := func(name string, dst *[]string) {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			*dst = strings.Split(v, ",")
+		}
+	}
+	num := func(name string, bits int, set func(uint64)) {
+		v, ok := os.LookupEnv(name)
+		if !ok || v == "" {
+			return
+		}
+		n, err := strconv.ParseUint(v, 10, bits)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: expected a non-negative integer, got %q", name, v))
+			return
+		}
+		set(n)
+	}
+
+	str("PORT", &c.Port)
+	str("HEALTH_PORT", &c.HealthPort)
+	str("DATABASE_URL", &c.DatabaseURL)
+	str("LOG_LEVEL", &c.LogLevel)
+	list("ALLOWED_ORIGINS", &c.AllowedOrigins)
+	num("MAX_CONNECTIONS_PER_IP", 31, func(n uint64) { c.RateLimit.MaxConnectionsPerIP = int(n) })
+	num("AUTH_ATTEMPTS_PER_MIN", 31, func(n uint64) { c.RateLimit.AuthAttemptsPerMin = int(n) })
+	str("TLS_CERT_FILE", &c.TLS.CertFile)
+	str("TLS_KEY_FILE", &c.TLS.KeyFile)
+	list("TLS_HOSTS", &c.TLS.Hosts)
+	if v, ok := os.LookupEnv("TLS_SELF_SIGNED"); ok && v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("TLS_SELF_SIGNED: expected true or false, got %q", v))
+		}
+		c.TLS.SelfSigned = b
+	}
+	num("ARGON2_MEMORY_KIB", 32, func(n uint64) { c.Argon2.MemoryKiB = uint32(n) })
+	num("ARGON2_TIME", 32, func(n uint64) { c.Argon2.Time = uint32(n) })
+	num("ARGON2_THREADS", 8, func(n uint64) { c.Argon2.Threads = uint8(n) })
+	str("ADMIN_TOKEN", &c.Admin.Token)
+	str("ATTACHMENTS_DIR", &c.Attachments.Dir)
+	num("ATTACHMENTS_MAX_FILE_MB", 31, func(n uint64) { c.Attachments.MaxFileMB = int(n) })
+	num("ATTACHMENTS_USER_QUOTA_MB", 31, func(n uint64) { c.Attachments.UserQuotaMB = int(n) })
+	num("ATTACHMENTS_TOTAL_QUOTA_MB", 31, func(n uint64) { c.Attachments.TotalQuotaMB = int(n) })
+
+	if len(errs) > 0 {
+		return fmt.Errorf("config: invalid environment:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Validate checks every field and returns one error listing all problems.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if p, err := strconv.Atoi(c.Port); err != nil || p < 1 || p > 65535 {
+		fail("port: must be a number between 1 and 65535, got %q", c.Port)
+	}
+	if c.HealthPort != "" {
+		if p, err := strconv.Atoi(c.HealthPort); err != nil || p < 1 || p > 65535 {
+			fail("health_port: must be a number between 1 and 65535, got %q", c.HealthPort)
+		} else if c.HealthPort == c.Port {
+			fail("health_port: must differ from port")
+		}
+	}
+	if c.DatabaseURL == "" {
+		fail("database_url: must not be empty")
+	}
+	if _, err := ParseLogLevel(c.LogLevel); err != nil {
+		fail("log_level: %v", err)
+	}
+	for _, o := range c.AllowedOrigins {
+		o = strings.TrimSpace(o)
+		if o != "*" && !strings.HasPrefix(o, "http://") && !strings.HasPrefix(o, "https://") {
+			fail("allowed_origins: %q must be \"*\" or start with http:// or https://", o)
+		}
+	}
+	if c.RateLimit.MaxConnectionsPerIP < 1 {
+		fail("rate_limit.max_connections_per_ip: must be at least 1, got %d", c.RateLimit.MaxConnectionsPerIP)
+	}
+	if c.RateLimit.AuthAttemptsPerMin < 1 {
+		fail("rate_limit.auth_attempts_per_min: must be at least 1, got %d", c.RateLimit.AuthAttemptsPerMin)
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		fail("tls: cert_file and key_file must be set together")
+	}
+	if c.TLS.CertFile != "" && c.TLS.SelfSigned {
+		fail("tls: self_signed cannot be combined with cert_file/key_file")
+	}
+	if c.Argon2.MemoryKiB < 8*uint32(c.Argon2.Threads) || c.Argon2.MemoryKiB == 0 {
+		fail("argon2.memory_kib: must be at least 8 per thread, got %d", c.Argon2.MemoryKiB)
+	}
+	if c.Argon2.Time < 1 {
+		fail("argon2.time: must be at least 1")
+	}
+	if c.Argon2.Threads < 1 {
+		fail("argon2.threads: must be at least 1")
+	}
+	if c.Admin.Token != "" && len(c.Admin.Token) < 16 {
+		fail("admin.token: must be at least 16 characters")
+	}
+	if c.Attachments.Dir == "" {
+		fail("attachments.dir: must not be empty")
+	}
+	if c.Attachments.MaxFileMB < 1 {
+		fail("attachments.max_file_mb: must be at least 1, got %d", c.Attachments.MaxFileMB)
+	}
+	if c.Attachments.UserQuotaMB < 0 {
+		fail("attachments.user_quota_mb: must not be negative, got %d", c.Attachments.UserQuotaMB)
+	}
+	if c.Attachments.TotalQuotaMB < 0 {
+		fail("attachments.total_quota_mb: must not be negative, got %d", c.Attachments.TotalQuotaMB)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("config: invalid settings:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// RestartRequired lists settings that differ from next but can only take
+// effect after a restart.
+func (c *Config) RestartRequired(next *Config) []string {
+	var changed []string
+	if c.Port != next.Port {
+		changed = append(changed, "port")
+	}
+	if c.HealthPort != next.HealthPort {
+		changed = append(changed, "health_port")
+	}
+	if c.DatabaseURL != next.DatabaseURL {
+		changed = append(changed, "database_url")
+	}
+	if c.TLS.CertFile != next.TLS.CertFile || c.TLS.KeyFile != next.TLS.KeyFile ||
+		c.TLS.SelfSigned != next.TLS.SelfSigned {
+		changed = append(changed, "tls")
+	}
+	if c.Argon2 != next.Argon2 {
+		changed = append(changed, "argon2")
+	}
+	if c.Attachments.Dir != next.Attachments.Dir {
+		changed = append(changed, "attachments.dir")
+	}
+	return changed
+}
+
+func ParseLogLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info", "":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown level %q (want debug, info, warn or error)", s)
+}
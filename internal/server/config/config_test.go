@@ -0,0 +1,105 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(body), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatalf("Defaults should be valid: %v", err)
+	}
+	if cfg.Port != "3567" || cfg.RateLimit.MaxConnectionsPerIP != 10 {
+		t.Errorf("Unexpected defaults: %+v", cfg)
+	}
+}
+
+func TestLoadFileAndEnv(t *testing.T) {
+	path := writeConfig(t, `
+port: "4000"
+log_level: debug
+allowed_origins: [https://a.example.com]
+rate_limit:
+  max_connections_per_ip: 3
+`)
+	t.Setenv("PORT", "5000")
+	t.Setenv("AUTH_ATTEMPTS_PER_MIN", "7")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+	if cfg.Port != "5000" {
+		t.Errorf("Env should override file, got port %s", cfg.Port)
+	}
+	if cfg.RateLimit.MaxConnectionsPerIP != 3 || cfg.RateLimit.AuthAttemptsPerMin != 7 {
+		t.Errorf("Unexpected rate limits: %+v", cfg.RateLimit)
+	}
+	if cfg.LogLevel != "debug" || len(cfg.AllowedOrigins) != 1 {
+		t.Errorf("File values not applied: %+v", cfg)
+	}
+}
+
+func TestLoadValidation(t *testing.T) {
+	path := writeConfig(t, `
+port: "99999"
+health_port: "99999"
+log_level: loud
+rate_limit:
+  max_connections_per_ip: 0
+tls:
+  cert_file: /tmp/cert.pem
+attachments:
+  max_file_mb: 0
+`)
+	_, err := Load(path)
+	if err == nil {
+		t.Fatal("Expected validation error")
+	}
+	for _, want := range []string{"port", "health_port", "log_level", "max_connections_per_ip", "cert_file and key_file", "max_file_mb"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Error should mention %q: %v", want, err)
+		}
+	}
+}
+
+func TestLoadRejectsUnknownFields(t *testing.T) {
+	path := writeConfig(t, "rate_limits:\n  max_connections_per_ip: 3\n")
+	if _, err := Load(path); err == nil {
+		t.Fatal("Expected error for misspelled key")
+	}
+}
+
+func TestLoadInvalidEnv(t *testing.T) {
+	t.Setenv("MAX_CONNECTIONS_PER_IP", "lots")
+	_, err := Load("")
+	if err == nil || !strings.Contains(err.Error(), "MAX_CONNECTIONS_PER_IP") {
+		t.Fatalf("Expected env error, got %v", err)
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	a, b := Default(), Default()
+	b.RateLimit.AuthAttemptsPerMin = 50
+	b.LogLevel = "debug"
+	b.Admin.Token = "a-new-admin-token-value"
+	if changed := a.RestartRequired(b); len(changed) != 0 {
+		t.Errorf("Reloadable settings flagged: %v", changed)
+	}
+	b.Port = "1"
+	if changed := a.RestartRequired(b); len(changed) != 1 || changed[0] != "port" {
+		t.Errorf("Expected port to require restart, got %v", changed)
+	}
+}
@@ -1,40 +1,47 @@
 package handlers
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
+	"sync/atomic"
 
+	"github.com/cloudzz-dev/cldzmsg/internal/server/metrics"
 	"github.com/cloudzz-dev/cldzmsg/internal/server/ratelimit"
 	"github.com/cloudzz-dev/cldzmsg/internal/server/ws"
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
-}
-
-func HealthCheck(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("OK"))
-}
+// connSeq numbers connections so their log lines can be correlated
+var connSeq atomic.Uint64
 
-func HandleWebSocket(hub *ws.Hub, limiter *ratelimit.RateLimiter, w http.ResponseWriter, r *http.Request) {
+func HandleWebSocket(hub *ws.Hub, limiter *ratelimit.RateLimiter, origins *OriginPolicy, w http.ResponseWriter, r *http.Request) {
 	clientIP := ratelimit.GetClientIP(r)
+	logger := slog.Default().With("conn_id", connSeq.Add(1), "ip", clientIP)
 
 	// Rate limit: check connection count per IP
 	if !limiter.CanConnect(clientIP) {
+		metrics.RateLimitRejections.WithLabelValues("connection").Inc()
 		http.Error(w, "Too many connections from your IP", http.StatusTooManyRequests)
-		log.Printf("Rate limited connection from %s", clientIP)
+		logger.Warn("Rate limited connection")
+		return
+	}
+
+	if !origins.Check(r) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		logger.Warn("Rejected origin", "origin", r.Header.Get("Origin"))
 		return
 	}
 
+	upgrader := websocket.Upgrader{CheckOrigin: origins.Check}
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println("Upgrade error:", err)
+		logger.Warn("WebSocket upgrade failed", "error", err)
 		return
 	}
 
 	limiter.AddConnection(clientIP)
+	metrics.ConnectedClients.Inc()
+	logger.Debug("Connection opened")
 
 	client := &ws.Client{
 		Hub:      hub,
@@ -42,12 +49,14 @@ func HandleWebSocket(hub *ws.Hub, limiter *ratelimit.RateLimiter, w http.Respons
 		Send:     make(chan []byte, 256),
 		Limiter:  limiter,
 		IP:       clientIP,
+		Logger:   logger,
 		UserID:   0, // Not authenticated yet
 		Username: "",
 	}
 
 	// Writer goroutine
 	go func() {
+		defer metrics.ConnectedClients.Dec()
 		defer limiter.RemoveConnection(clientIP)
 		client.WritePump()
 	}()
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/cloudzz-dev/cldzmsg/internal/server/metrics"
+)
+
+// Check is a named readiness dependency, e.g. the database or the hub.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Health serves /livez and /readyz. Liveness only says the process is
+// serving HTTP; readiness runs every check with a timeout. Both are
+// unauthenticated, so failures are logged rather than reported.
+type Health struct {
+	Version            string
+	Timeout            time.Duration
+	Checks             []Check
+	AuthenticatedUsers func() int
+	started            time.Time
+}
+
+func NewHealth(version string, checks ...Check) *Health {
+	return &Health{
+		Version: version,
+		Timeout: 2 * time.Second,
+		Checks:  checks,
+		started: time.Now(),
+	}
+}
+
+type checkResult struct {
+	Status    string `json:"status"`
+	LatencyMS int64  `json:"latency_ms"`
+}
+
+type healthReport struct {
+	Status             string                 `json:"status"`
+	Version            string                 `json:"version"`
+	Uptime             string                 `json:"uptime"`
+	UptimeSeconds      int64                  `json:"uptime_seconds"`
+	ConnectedClients   int64                  `json:"connected_clients"`
+	AuthenticatedUsers int                    `json:"authenticated_users"`
+	Checks             map[string]checkResult `json:"checks,omitempty"`
+}
+
+func (h *Health) Livez(w http.ResponseWriter, r *http.Request) {
+	h.write(w, http.StatusOK, h.report("ok"))
+}
+
+func (h *Health) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), h.Timeout)
+	defer cancel()
+
+	report := h.report("ok")
+	report.Checks = make(map[string]checkResult, len(h.Checks))
+	status := http.StatusOK
+
+	for _, c := range h.Checks {
+		start := time.Now()
+		err := c.Run(ctx)
+		res := checkResult{Status: "ok", LatencyMS: time.Since(start).Milliseconds()}
+		if err != nil {
+			res.Status = "fail"
+			slog.Warn("Readiness check failed", "check", c.Name, "error", err)
+			report.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		}
+		report.Checks[c.Name] = res
+	}
+
+	h.write(w, status, report)
+}
+
+func (h *Health) report(status string) healthReport {
+	uptime := time.Since(h.started).Truncate(time.Second)
+	report := healthReport{
+		Status:           status,
+		Version:          h.Version,
+		Uptime:           uptime.String(),
+		UptimeSeconds:    int64(uptime.Seconds()),
+		ConnectedClients: metrics.Connections(),
+	}
+	if h.AuthenticatedUsers != nil {
+		report.AuthenticatedUsers = h.AuthenticatedUsers()
+	}
+	return report
+}
+
+func (h *Health) write(w http.ResponseWriter, status int, report healthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
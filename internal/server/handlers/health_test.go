@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadyz(t *testing.T) {
+	ok := Check{Name: "hub", Run: func(ctx context.Context) error { return nil }}
+	down := Check{Name: "database", Run: func(ctx context.Context) error { return errors.New("connection refused") }}
+	slow := Check{Name: "database", Run: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+
+	tests := []struct {
+		name   string
+		checks []Check
+		want   int
+	}{
+		{"all ok", []Check{ok}, http.StatusOK},
+		{"dependency down", []Check{ok, down}, http.StatusServiceUnavailable},
+		{"dependency hangs", []Check{slow}, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		h := NewHealth("test", tt.checks...)
+		h.Timeout = 50 * time.Millisecond
+
+		rec := httptest.NewRecorder()
+		h.Readyz(rec, httptest.NewRequest("GET", "/readyz", nil))
+
+		if rec.Code != tt.want {
+			t.Errorf("%s: got status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+		var report healthReport
+		if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+			t.Fatalf("%s: invalid JSON: %v", tt.name, err)
+		}
+		if report.Version != "test" || len(report.Checks) == 0 {
+			t.Errorf("%s: incomplete report: %+v", tt.name, report)
+		}
+		if strings.Contains(rec.Body.String(), "connection refused") {
+			t.Errorf("%s: error detail leaked: %s", tt.name, rec.Body.String())
+		}
+	}
+}
+
+func TestLivezIgnoresChecks(t *testing.T) {
+	down := Check{Name: "database", Run: func(ctx context.Context) error { return errors.New("down") }}
+	rec := httptest.NewRecorder()
+	NewHealth("test", down).Livez(rec, httptest.NewRequest("GET", "/livez", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Liveness should not depend on the database, got %d", rec.Code)
+	}
+}
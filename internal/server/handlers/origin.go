@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// OriginPolicy decides which browser origins may open a WebSocket.
+// Requests without an Origin header (the TUI client, curl, ...) are always
+// allowed since origin checks only protect browsers from cross-site use.
+type OriginPolicy struct {
+	mu      sync.RWMutex
+	allowed []string
+}
+
+// NewOriginPolicy accepts exact origins like "https://chat.example.com",
+// wildcard subdomains like "https://*.example.com", or "*" for any origin.
+// With an empty list only same-host origins are accepted.
+func NewOriginPolicy(allowed []string) *OriginPolicy {
+	p := &OriginPolicy{}
+	p.Set(allowed)
+	return p
+}
+
+func (p *OriginPolicy) Set(allowed []string) {
+	normalized := make([]string, 0, len(allowed))
+	for _, o := range allowed {
+		o = strings.ToLower(strings.TrimRight(strings.TrimSpace(o), "/"))
+		if o != "" {
+			normalized = append(normalized, o)
+		}
+	}
+	p.mu.Lock()
+	p.allowed = normalized
+	p.mu.Unlock()
+}
+
+func (p *OriginPolicy) Allowed() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]string(nil), p.allowed...)
+}
+
+func (p *OriginPolicy) Check(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if len(p.allowed) == 0 {
+		return strings.EqualFold(u.Host, r.Host)
+	}
+
+	origin = strings.ToLower(u.Scheme + "://" + u.Host)
+	for _, a := range p.allowed {
+		if a == "*" || a == origin {
+			return true
+		}
+		// "https://*.example.com" matches any subdomain, not the apex
+		if scheme, host, ok := strings.Cut(a, "://*."); ok {
+			if strings.ToLower(u.Scheme) == scheme && strings.HasSuffix(strings.ToLower(u.Host), "."+host) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginPolicy(t *testing.T) {
+	tests := []struct {
+		allowed []string
+		host    string
+		origin  string
+		want    bool
+	}{
+		{nil, "chat.example.com", "", true},
+		{nil, "chat.example.com", "https://chat.example.com", true},
+		{nil, "chat.example.com", "https://evil.com", false},
+		{[]string{"https://app.example.com/"}, "chat.example.com", "https://app.example.com", true},
+		{[]string{"https://app.example.com"}, "chat.example.com", "http://app.example.com", false},
+		{[]string{"https://*.example.com"}, "chat.example.com", "https://a.example.com", true},
+		{[]string{"https://*.example.com"}, "chat.example.com", "https://example.com", false},
+		{[]string{"https://*.example.com"}, "chat.example.com", "https://evilexample.com", false},
+		{[]string{"*"}, "chat.example.com", "https://anything.org", true},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/ws", nil)
+		r.Host = tt.host
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := NewOriginPolicy(tt.allowed).Check(r); got != tt.want {
+			t.Errorf("allowed=%v origin=%q: got %v, want %v", tt.allowed, tt.origin, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const namespace = "cldzmsg"
+
+var (
+	ConnectedClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "connected_clients",
+		Help:      "Open WebSocket connections, authenticated or not.",
+	})
+
+	MessagesSent = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_sent_total",
+		Help:      "Chat messages stored and broadcast.",
+	})
+
+	ActionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ws_action_duration_seconds",
+		Help:      "Time spent handling a WebSocket action in ProcessMessage.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"action"})
+
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Latency of storage.Store operations.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"query"})
+
+	RateLimitRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_rejections_total",
+		Help:      "Requests rejected by the rate limiter.",
+	}, []string{"kind"}) // "connection" or "auth"
+
+	SendDrops = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "send_drops_total",
+		Help:      "Clients dropped by the hub because their Send buffer was full.",
+	})
+)
+
+// RegisterAuthenticatedUsers exposes a gauge computed on scrape, so the hub
+// stays the single source of truth for who is online.
+func RegisterAuthenticatedUsers(count func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "authenticated_users",
+		Help:      "Distinct users with at least one authenticated connection.",
+	}, func() float64 { return float64(count()) })
+}
+
+// Connections reads ConnectedClients, for reports outside Prometheus.
+func Connections() int64 {
+	var m dto.Metric
+	if err := ConnectedClients.Write(&m); err != nil {
+		return 0
+	}
+	return int64(m.GetGauge().GetValue())
+}
+
+// ObserveQuery records the duration of a storage call started at start.
+// Use as: defer metrics.ObserveQuery("get_user", time.Now())
+func ObserveQuery(query string, start time.Time) {
+	DBQueryDuration.WithLabelValues(query).Observe(time.Since(start).Seconds())
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -9,25 +9,100 @@ type User struct {
 	ID           int       `json:"id"`
 	Username     string    `json:"username"`
 	PasswordHash string    `json:"-"`
+	TOTPSecret   string    `json:"-"`
+	TOTPEnabled  bool      `json:"-"`
+	TOTPLastStep int64     `json:"-"`
+	Disabled     bool      `json:"disabled"`
 	CreatedAt    time.Time `json:"created_at"`
 }
 
+type RecoveryCode struct {
+	ID       int
+	CodeHash string
+}
+
 type Message struct {
 	ID             int       `json:"id"`
 	ConversationID int       `json:"conversation_id"`
 	SenderID       int       `json:"sender_id"`
 	SenderUsername string    `json:"sender_username,omitempty"`
+	Kind           string    `json:"kind"`
 	Content        string    `json:"content"`
 	CreatedAt      time.Time `json:"created_at"`
+
+	// Participants @mentioned in the content, from message_mentions
+	Mentions []int `json:"mentions,omitempty"`
+
+	// File shared with the message, whose content is then its caption
+	Attachment *Attachment `json:"attachment,omitempty"`
+
+	// Not stored: ClientID echoes the sender's send_message so it can match
+	// its pending copy, DeliveredTo lists recipients of the sender's own
+	// messages whose devices have them.
+	ClientID    string `json:"client_id,omitempty"`
+	DeliveredTo []int  `json:"delivered_to,omitempty"`
+}
+
+// Attachment describes a file stored by the server. Clients download it
+// by ID in chunks.
+type Attachment struct {
+	ID         int       `json:"id"`
+	Name       string    `json:"name"`
+	MimeType   string    `json:"mime_type"`
+	Size       int64     `json:"size"`
+	SHA256     string    `json:"sha256"`
+	CreatedAt  time.Time `json:"created_at"`
+	StorageKey string    `json:"-"` // File name in the attachments directory
+}
+
+// Delivery is a message newly received by a recipient's device.
+type Delivery struct {
+	MessageID      int
+	ConversationID int
+	SenderID       int
+}
+
+// Message kinds. System messages record membership and name changes; their
+// content is a ready-to-display sentence.
+const (
+	MessageText          = "text"
+	MessageMemberAdded   = "member_added"
+	MessageMemberRemoved = "member_removed"
+	MessageMemberLeft    = "member_left"
+	MessageRenamed       = "renamed"
+)
+
+// Roles within a group conversation. DMs only have members.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+type Participant struct {
+	UserID     int       `json:"user_id"`
+	Username   string    `json:"username"`
+	Role       string    `json:"role"`
+	JoinedAt   time.Time `json:"joined_at"`
+	LastReadAt time.Time `json:"last_read_at"`
+	Online     bool      `json:"online"` // Filled from the hub, not stored
 }
 
 type Conversation struct {
-	ID           int       `json:"id"`
-	Name         *string   `json:"name,omitempty"`
-	IsGroup      bool      `json:"is_group"`
-	CreatedAt    time.Time `json:"created_at"`
-	Participants []string  `json:"participants,omitempty"`
-	UnreadCount  int       `json:"unread_count"`
+	ID           int           `json:"id"`
+	Name         *string       `json:"name,omitempty"`
+	IsGroup      bool          `json:"is_group"`
+	CreatedAt    time.Time     `json:"created_at"`
+	Participants []Participant `json:"participants,omitempty"`
+	UnreadCount  int           `json:"unread_count"`
+	LastMessage  *Message      `json:"last_message,omitempty"` // Content truncated for previews
+	MessageCount int           `json:"message_count,omitempty"`
+
+	// The requesting user's own settings
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+	Archived   bool       `json:"archived"`
+	Pinned     bool       `json:"pinned"`
+	SortOrder  int        `json:"sort_order"`
 }
 
 // WS Message Types
@@ -43,9 +118,52 @@ type AuthPayload struct {
 	Action   string `json:"action"` // "login" or "register"
 }
 
+type TOTPCodePayload struct {
+	Code string `json:"code"`
+}
+
 type SendMessagePayload struct {
 	ConversationID int    `json:"conversation_id"`
 	Content        string `json:"content"`
+	ClientID       string `json:"client_id,omitempty"` // Echoed back in new_message
+}
+
+// UploadStartPayload announces a file to share in a conversation. The
+// message is posted with Caption once all chunks have arrived.
+type UploadStartPayload struct {
+	ConversationID int    `json:"conversation_id"`
+	Name           string `json:"name"`
+	Size           int64  `json:"size"`
+	Caption        string `json:"caption,omitempty"`
+	ClientID       string `json:"client_id,omitempty"` // Echoed back in new_message
+}
+
+// UploadChunkPayload carries the next part of an upload; Data is base64 in
+// JSON.
+type UploadChunkPayload struct {
+	UploadID string `json:"upload_id"`
+	Offset   int64  `json:"offset"`
+	Data     []byte `json:"data"`
+}
+
+// DownloadPayload asks for the chunk of an attachment starting at Offset.
+type DownloadPayload struct {
+	AttachmentID int   `json:"attachment_id"`
+	Offset       int64 `json:"offset"`
+}
+
+// ConversationSettingsPayload changes the caller's settings for one
+// conversation. Nil fields are left as they are.
+type ConversationSettingsPayload struct {
+	ConversationID int   `json:"conversation_id"`
+	MuteMinutes    *int  `json:"mute_minutes,omitempty"` // 0 unmutes, negative mutes until unmuted
+	Archived       *bool `json:"archived,omitempty"`
+	Pinned         *bool `json:"pinned,omitempty"`
+	SortOrder      *int  `json:"sort_order,omitempty"`
+}
+
+type MessageAckPayload struct {
+	MessageIDs []int `json:"message_ids"`
 }
 
 type CreateConversationPayload struct {
@@ -58,6 +176,21 @@ type CheckUserPayload struct {
 	Username string `json:"username"`
 }
 
+// ParticipantPayload targets one member of a conversation, for
+// add/remove_participant, promote, demote and transfer_ownership.
+type ParticipantPayload struct {
+	ConversationID int    `json:"conversation_id"`
+	Username       string `json:"username"`
+}
+
 type ReadReceiptPayload struct {
 	ConversationID int `json:"conversation_id"`
+	MessageID      int `json:"message_id,omitempty"` // Read up to here; 0 means everything
+}
+
+// ReadState is how far one participant has read a conversation.
+type ReadState struct {
+	UserID            int    `json:"user_id"`
+	Username          string `json:"username"`
+	LastReadMessageID int    `json:"last_read_message_id"`
 }
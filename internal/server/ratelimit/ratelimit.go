@@ -3,8 +3,6 @@ package ratelimit
 import (
 	"net"
 	"net/http"
-	"os"
-	"strconv"
 	"sync"
 	"time"
 )
@@ -17,21 +15,7 @@ type RateLimiter struct {
 	maxAuth      int
 }
 
-func New() *RateLimiter {
-	maxConns := 10
-	if v := os.Getenv("MAX_CONNECTIONS_PER_IP"); v != "" {
-		if n, err := strconv.Atoi(v); err == nil {
-			maxConns = n
-		}
-	}
-
-	maxAuth := 5
-	if v := os.Getenv("AUTH_ATTEMPTS_PER_MIN"); v != "" {
-		if n, err := strconv.Atoi(v); err == nil {
-			maxAuth = n
-		}
-	}
-
+func New(maxConns, maxAuth int) *RateLimiter {
 	rl := &RateLimiter{
 		connections:  make(map[string]int),
 		authAttempts: make(map[string][]time.Time),
@@ -50,6 +34,15 @@ func New() *RateLimiter {
 	return rl
 }
 
+// SetLimits changes the limits in place. Existing connections are kept even
+// if an IP is now over the new connection limit.
+func (rl *RateLimiter) SetLimits(maxConns, maxAuth int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.maxConns = maxConns
+	rl.maxAuth = maxAuth
+}
+
 func (rl *RateLimiter) cleanup() {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
@@ -112,6 +105,42 @@ func (rl *RateLimiter) CanAuth(ip string) bool {
 	return true
 }
 
+// Snapshot is a point-in-time copy of the limiter state.
+type Snapshot struct {
+	MaxConnectionsPerIP int            `json:"max_connections_per_ip"`
+	AuthAttemptsPerMin  int            `json:"auth_attempts_per_min"`
+	Connections         map[string]int `json:"connections"`
+	AuthAttempts        map[string]int `json:"auth_attempts"` // Attempts in the last minute
+}
+
+func (rl *RateLimiter) Snapshot() Snapshot {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	snap := Snapshot{
+		MaxConnectionsPerIP: rl.maxConns,
+		AuthAttemptsPerMin:  rl.maxAuth,
+		Connections:         make(map[string]int, len(rl.connections)),
+		AuthAttempts:        make(map[string]int, len(rl.authAttempts)),
+	}
+	for ip, n := range rl.connections {
+		snap.Connections[ip] = n
+	}
+	cutoff := time.Now().Add(-time.Minute)
+	for ip, attempts := range rl.authAttempts {
+		n := 0
+		for _, t := range attempts {
+			if t.After(cutoff) {
+				n++
+			}
+		}
+		if n > 0 {
+			snap.AuthAttempts[ip] = n
+		}
+	}
+	return snap
+}
+
 func GetClientIP(r *http.Request) string {
 	// Check X-Forwarded-For header (for reverse proxies)
 	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/cloudzz-dev/cldzmsg/internal/db"
+	"github.com/cloudzz-dev/cldzmsg/internal/server/metrics"
+	"github.com/cloudzz-dev/cldzmsg/internal/server/models"
+)
+
+// Admin Methods
+//
+// Queries used only by the admin API and tooling, never by chat clients.
+
+// SearchUsers lists users whose name contains query (all users if empty).
+func (s *Store) SearchUsers(query string, limit, offset int) ([]models.User, error) {
+	defer metrics.ObserveQuery("search_users", time.Now())
+
+	rows, err := s.db.Query(`
+		SELECT id, username, COALESCE(totp_enabled, FALSE), COALESCE(disabled, FALSE), created_at
+		FROM users
+		WHERE $1 = '' OR username ILIKE '%' || $1 || '%'
+		ORDER BY username
+		LIMIT $2 OFFSET $3
+	`, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var u models.User
+		if err := rows.Scan(&u.ID, &u.Username, &u.TOTPEnabled, &u.Disabled, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// SetUserDisabled returns sql.ErrNoRows if the user does not exist.
+func (s *Store) SetUserDisabled(userID int, disabled bool) error {
+	defer metrics.ObserveQuery("set_user_disabled", time.Now())
+
+	res, err := s.db.Exec("UPDATE users SET disabled = $1 WHERE id = $2", disabled, userID)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(res)
+}
+
+// DeleteMessage removes a message and returns it so clients can be told.
+// Its attachment, if any, comes back with only StorageKey set, for the
+// caller to delete the file.
+func (s *Store) DeleteMessage(messageID int) (*models.Message, error) {
+	defer metrics.ObserveQuery("delete_message", time.Now())
+
+	var m models.Message
+	var senderID sql.NullInt64
+	var storageKey sql.NullString
+	err := s.db.QueryRow(`
+		DELETE FROM messages WHERE id = $1
+		RETURNING id, conversation_id, sender_id, created_at,
+			(SELECT storage_key FROM attachments WHERE message_id = $1)
+	`, messageID).Scan(&m.ID, &m.ConversationID, &senderID, &m.CreatedAt, &storageKey)
+	if err != nil {
+		return nil, err
+	}
+	m.SenderID = int(senderID.Int64)
+	if storageKey.Valid {
+		m.Attachment = &models.Attachment{StorageKey: storageKey.String}
+	}
+	return &m, nil
+}
+
+// GetConversation loads a conversation with its message count, regardless
+// of who is asking.
+func (s *Store) GetConversation(convID int) (*models.Conversation, int, error) {
+	defer metrics.ObserveQuery("get_conversation", time.Now())
+
+	var c models.Conversation
+	var count int
+	err := s.db.QueryRow(`
+		SELECT c.id, c.name, c.is_group, c.created_at,
+			(SELECT COUNT(*) FROM messages m WHERE m.conversation_id = c.id)
+		FROM conversations c
+		WHERE c.id = $1
+	`, convID).Scan(&c.ID, &c.Name, &c.IsGroup, &c.CreatedAt, &count)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &c, count, nil
+}
+
+func (s *Store) GetParticipants(convID int) ([]models.Participant, error) {
+	defer metrics.ObserveQuery("get_participants", time.Now())
+
+	rows, err := s.db.Query(`
+		SELECT u.id, u.username, cp.role, cp.joined_at, cp.last_read_at
+		FROM conversation_participants cp
+		JOIN users u ON u.id = cp.user_id
+		WHERE cp.conversation_id = $1
+		ORDER BY CASE cp.role WHEN 'owner' THEN 0 WHEN 'admin' THEN 1 ELSE 2 END, cp.joined_at, u.username
+	`, convID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var participants []models.Participant
+	for rows.Next() {
+		var p models.Participant
+		if err := rows.Scan(&p.UserID, &p.Username, &p.Role, &p.JoinedAt, &p.LastReadAt); err != nil {
+			return nil, err
+		}
+		participants = append(participants, p)
+	}
+	return participants, rows.Err()
+}
+
+func expectOneRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Migrate applies the embedded schema migrations.
+func (s *Store) Migrate(ctx context.Context) ([]string, error) {
+	return db.Migrate(ctx, s.db)
+}
+
+type Stats struct {
+	Users              int `json:"users"`
+	DisabledUsers      int `json:"disabled_users"`
+	TOTPUsers          int `json:"totp_users"`
+	Conversations      int `json:"conversations"`
+	Groups             int `json:"groups"`
+	Messages           int `json:"messages"`
+	MessagesLast24h    int `json:"messages_last_24h"`
+	ActiveSendersLast7 int `json:"active_senders_last_7d"`
+}
+
+func (s *Store) Stats() (*Stats, error) {
+	defer metrics.ObserveQuery("stats", time.Now())
+
+	var st Stats
+	err := s.db.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM users),
+			(SELECT COUNT(*) FROM users WHERE disabled),
+			(SELECT COUNT(*) FROM users WHERE totp_enabled),
+			(SELECT COUNT(*) FROM conversations),
+			(SELECT COUNT(*) FROM conversations WHERE is_group),
+			(SELECT COUNT(*) FROM messages),
+			(SELECT COUNT(*) FROM messages WHERE created_at > NOW() - INTERVAL '24 hours'),
+			(SELECT COUNT(DISTINCT sender_id) FROM messages WHERE created_at > NOW() - INTERVAL '7 days')
+	`).Scan(&st.Users, &st.DisabledUsers, &st.TOTPUsers, &st.Conversations, &st.Groups,
+		&st.Messages, &st.MessagesLast24h, &st.ActiveSendersLast7)
+	if err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
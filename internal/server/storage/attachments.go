@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/cloudzz-dev/cldzmsg/internal/server/metrics"
+	"github.com/cloudzz-dev/cldzmsg/internal/server/models"
+)
+
+// Attachment Methods
+//
+// The files themselves are kept by internal/server/attachments; these
+// tables hold what they are and which message shared them.
+
+// SaveAttachment posts a message sharing an uploaded file, with caption as
+// its content, and fills in a's ID and creation time. Like SaveMessage, a
+// clientID the sender already used returns the stored message with created
+// false; a is then not stored, and its file is the caller's to remove.
+func (s *Store) SaveAttachment(convID, senderID int, caption, clientID string, a *models.Attachment) (msg *models.Message, created bool, err error) {
+	defer metrics.ObserveQuery("save_attachment", time.Now())
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	msg = &models.Message{}
+	err = tx.QueryRow(`
+		INSERT INTO messages (conversation_id, sender_id, kind, content, client_id)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+		ON CONFLICT (sender_id, client_id) WHERE client_id IS NOT NULL DO NOTHING
+		RETURNING id, conversation_id, sender_id, kind, content, created_at
+	`, convID, senderID, models.MessageText, caption, clientID).Scan(&msg.ID, &msg.ConversationID, &msg.SenderID, &msg.Kind, &msg.Content, &msg.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) && clientID != "" {
+		tx.Rollback()
+		msg, err = s.GetSentMessage(senderID, clientID)
+		return msg, false, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	err = tx.QueryRow(`
+		INSERT INTO attachments (message_id, uploader_id, name, mime_type, size, sha256, storage_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at
+	`, msg.ID, senderID, a.Name, a.MimeType, a.Size, a.SHA256, a.StorageKey).Scan(&a.ID, &a.CreatedAt)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+
+	user, err := s.GetUserByID(senderID)
+	if err != nil {
+		s.log.Warn("Failed to load sender for message", "message_id", msg.ID, "sender_id", senderID, "error", err)
+	} else {
+		msg.SenderUsername = user.Username
+	}
+	msg.Attachment = a
+	return msg, true, nil
+}
+
+// GetAttachment loads an attachment with the conversation it was shared in.
+func (s *Store) GetAttachment(id int) (a *models.Attachment, convID int, err error) {
+	defer metrics.ObserveQuery("get_attachment", time.Now())
+
+	a = &models.Attachment{}
+	err = s.db.QueryRow(`
+		SELECT a.id, a.name, a.mime_type, a.size, a.sha256, a.created_at, a.storage_key, m.conversation_id
+		FROM attachments a
+		JOIN messages m ON m.id = a.message_id
+		WHERE a.id = $1
+	`, id).Scan(&a.ID, &a.Name, &a.MimeType, &a.Size, &a.SHA256, &a.CreatedAt, &a.StorageKey, &convID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return a, convID, nil
+}
+
+// GetAttachments maps each message of a conversation from sinceID on to
+// the file it shares.
+func (s *Store) GetAttachments(convID, sinceID int) (map[int]*models.Attachment, error) {
+	defer metrics.ObserveQuery("get_attachments", time.Now())
+
+	rows, err := s.db.Query(`
+		SELECT a.message_id, a.id, a.name, a.mime_type, a.size, a.sha256, a.created_at
+		FROM attachments a
+		JOIN messages m ON m.id = a.message_id
+		WHERE m.conversation_id = $1 AND m.id >= $2
+	`, convID, sinceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := make(map[int]*models.Attachment)
+	for rows.Next() {
+		var msgID int
+		var a models.Attachment
+		if err := rows.Scan(&msgID, &a.ID, &a.Name, &a.MimeType, &a.Size, &a.SHA256, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		attachments[msgID] = &a
+	}
+	return attachments, rows.Err()
+}
+
+// AttachmentUsage returns how many bytes of files the user has uploaded,
+// and how many are stored in total.
+func (s *Store) AttachmentUsage(userID int) (user, total int64, err error) {
+	defer metrics.ObserveQuery("attachment_usage", time.Now())
+
+	err = s.db.QueryRow(`
+		SELECT COALESCE(SUM(size) FILTER (WHERE uploader_id = $1), 0), COALESCE(SUM(size), 0)
+		FROM attachments
+	`, userID).Scan(&user, &total)
+	return user, total, err
+}
+
+// AttachmentKeys returns the storage key of every stored file, to find
+// files on disk that no longer belong to a message.
+func (s *Store) AttachmentKeys() (map[string]bool, error) {
+	defer metrics.ObserveQuery("attachment_keys", time.Now())
+
+	rows, err := s.db.Query("SELECT storage_key FROM attachments")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make(map[string]bool)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys[key] = true
+	}
+	return keys, rows.Err()
+}
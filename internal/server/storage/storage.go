@@ -1,36 +1,58 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"time"
 
+	"github.com/cloudzz-dev/cldzmsg/internal/server/metrics"
 	"github.com/cloudzz-dev/cldzmsg/internal/server/models"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
-type Store struct {
-	db *sql.DB
+var (
+	ErrUsernameTaken  = errors.New("username is already taken")
+	ErrDMNeedsOneUser = errors.New("a direct message needs exactly one other user")
+)
+
+// UserNotFoundError reports a username that doesn't exist. Its message is
+// safe to show to users.
+type UserNotFoundError struct {
+	Username string
 }
 
-func New() *Store {
-	connStr := os.Getenv("DATABASE_URL")
-	if connStr == "" {
-		connStr = "postgres://localhost/cldzmsg?sslmode=disable"
-	}
+func (e *UserNotFoundError) Error() string {
+	return fmt.Sprintf("user %s not found", e.Username)
+}
 
+type Store struct {
+	db  *sql.DB
+	log *slog.Logger
+}
+
+func New(connStr string) *Store {
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		slog.Error("Failed to connect to database", "error", err)
+		os.Exit(1)
 	}
 
 	if err = db.Ping(); err != nil {
-		log.Fatal("Failed to ping database:", err)
+		slog.Error("Failed to ping database", "error", err)
+		os.Exit(1)
 	}
 
-	log.Println("Connected to database")
-	return &Store{db: db}
+	slog.Info("Connected to database")
+	return &Store{db: db, log: slog.Default().With("component", "storage")}
+}
+
+// Ping checks the database is reachable, for readiness probes.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
 }
 
 func (s *Store) Close() {
@@ -40,27 +62,46 @@ func (s *Store) Close() {
 // User Methods
 
 func (s *Store) CreateUser(username, passwordHash string) (int, error) {
+	defer metrics.ObserveQuery("create_user", time.Now())
+
 	var userID int
 	err := s.db.QueryRow(
 		"INSERT INTO users (username, password_hash) VALUES ($1, $2) RETURNING id",
 		username, passwordHash,
 	).Scan(&userID)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return 0, ErrUsernameTaken
+	}
 	return userID, err
 }
 
 func (s *Store) GetUserByUsername(username string) (*models.User, error) {
+	defer metrics.ObserveQuery("get_user_by_username", time.Now())
+
 	var u models.User
+	var secret sql.NullString
 	err := s.db.QueryRow(
-		"SELECT id, username, password_hash FROM users WHERE username = $1",
+		"SELECT id, username, password_hash, totp_secret, COALESCE(totp_enabled, FALSE), COALESCE(totp_last_step, 0), COALESCE(disabled, FALSE) FROM users WHERE username = $1",
 		username,
-	).Scan(&u.ID, &u.Username, &u.PasswordHash)
+	).Scan(&u.ID, &u.Username, &u.PasswordHash, &secret, &u.TOTPEnabled, &u.TOTPLastStep, &u.Disabled)
 	if err != nil {
 		return nil, err
 	}
+	u.TOTPSecret = secret.String
 	return &u, nil
 }
 
+func (s *Store) UpdatePasswordHash(userID int, passwordHash string) error {
+	defer metrics.ObserveQuery("update_password_hash", time.Now())
+
+	_, err := s.db.Exec("UPDATE users SET password_hash = $1 WHERE id = $2", passwordHash, userID)
+	return err
+}
+
 func (s *Store) GetUserByID(id int) (*models.User, error) {
+	defer metrics.ObserveQuery("get_user_by_id", time.Now())
+
 	var u models.User
 	err := s.db.QueryRow(
 		"SELECT id, username FROM users WHERE id = $1",
@@ -73,17 +114,154 @@ func (s *Store) GetUserByID(id int) (*models.User, error) {
 }
 
 func (s *Store) CheckUserExists(username string) (bool, int) {
+	defer metrics.ObserveQuery("check_user_exists", time.Now())
+
 	var userID int
 	err := s.db.QueryRow("SELECT id FROM users WHERE username = $1", username).Scan(&userID)
 	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			s.log.Error("User lookup failed", "username", username, "error", err)
+		}
 		return false, 0
 	}
 	return true, userID
 }
 
+// Two-Factor Methods
+
+func (s *Store) GetTOTP(userID int) (secret string, enabled bool, lastStep int64, err error) {
+	defer metrics.ObserveQuery("get_totp", time.Now())
+
+	var ns sql.NullString
+	err = s.db.QueryRow(
+		"SELECT totp_secret, COALESCE(totp_enabled, FALSE), COALESCE(totp_last_step, 0) FROM users WHERE id = $1",
+		userID,
+	).Scan(&ns, &enabled, &lastStep)
+	return ns.String, enabled, lastStep, err
+}
+
+// SetPendingTOTP stores a new secret that is not enforced until EnableTOTP.
+func (s *Store) SetPendingTOTP(userID int, secret string) error {
+	defer metrics.ObserveQuery("set_pending_totp", time.Now())
+
+	_, err := s.db.Exec(
+		"UPDATE users SET totp_secret = $1, totp_enabled = FALSE, totp_last_step = 0 WHERE id = $2",
+		secret, userID,
+	)
+	return err
+}
+
+// EnableTOTP turns on 2FA and replaces any existing recovery codes.
+func (s *Store) EnableTOTP(userID int, lastStep int64, codeHashes []string) error {
+	defer metrics.ObserveQuery("enable_totp", time.Now())
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		"UPDATE users SET totp_enabled = TRUE, totp_last_step = $1 WHERE id = $2",
+		lastStep, userID,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	for _, h := range codeHashes {
+		if _, err := tx.Exec(
+			"INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)",
+			userID, h,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *Store) DisableTOTP(userID int) error {
+	defer metrics.ObserveQuery("disable_totp", time.Now())
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		"UPDATE users SET totp_secret = NULL, totp_enabled = FALSE, totp_last_step = 0 WHERE id = $1",
+		userID,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AdvanceTOTPStep records an accepted step. It reports false if another
+// login already consumed this or a later step.
+func (s *Store) AdvanceTOTPStep(userID int, step int64) (bool, error) {
+	defer metrics.ObserveQuery("advance_totp_step", time.Now())
+
+	res, err := s.db.Exec(
+		"UPDATE users SET totp_last_step = $1 WHERE id = $2 AND COALESCE(totp_last_step, 0) < $1",
+		step, userID,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (s *Store) GetUnusedRecoveryCodes(userID int) ([]models.RecoveryCode, error) {
+	defer metrics.ObserveQuery("get_unused_recovery_codes", time.Now())
+
+	rows, err := s.db.Query(
+		"SELECT id, code_hash FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var codes []models.RecoveryCode
+	for rows.Next() {
+		var rc models.RecoveryCode
+		if err := rows.Scan(&rc.ID, &rc.CodeHash); err != nil {
+			return nil, err
+		}
+		codes = append(codes, rc)
+	}
+	return codes, rows.Err()
+}
+
+// UseRecoveryCode marks a code as spent. It reports false if it was already used.
+func (s *Store) UseRecoveryCode(id int) (bool, error) {
+	defer metrics.ObserveQuery("use_recovery_code", time.Now())
+
+	res, err := s.db.Exec("UPDATE recovery_codes SET used_at = NOW() WHERE id = $1 AND used_at IS NULL", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
 // Conversation Methods
 
 func (s *Store) CreateConversation(creatorID int, payload models.CreateConversationPayload) (*models.Conversation, error) {
+	defer metrics.ObserveQuery("create_conversation", time.Now())
+
+	if !payload.IsGroup {
+		return s.getOrCreateDM(creatorID, payload)
+	}
+
 	tx, err := s.db.Begin()
 	if err != nil {
 		return nil, err
@@ -104,10 +282,10 @@ func (s *Store) CreateConversation(creatorID int, payload models.CreateConversat
 		return nil, err
 	}
 
-	// Add creator
+	// Add creator, who owns the group
 	_, err = tx.Exec(
-		"INSERT INTO conversation_participants (conversation_id, user_id) VALUES ($1, $2)",
-		convID, creatorID,
+		"INSERT INTO conversation_participants (conversation_id, user_id, role) VALUES ($1, $2, $3)",
+		convID, creatorID, models.RoleOwner,
 	)
 	if err != nil {
 		return nil, err
@@ -116,11 +294,15 @@ func (s *Store) CreateConversation(creatorID int, payload models.CreateConversat
 	// Add other participants
 	for _, username := range payload.Usernames {
 		exists, userID := s.CheckUserExists(username)
-		if exists {
-			tx.Exec(
-				"INSERT INTO conversation_participants (conversation_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
-				convID, userID,
-			)
+		if !exists {
+			s.log.Info("Skipping unknown participant", "conversation_id", convID, "username", username)
+			continue
+		}
+		if _, err := tx.Exec(
+			"INSERT INTO conversation_participants (conversation_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+			convID, userID,
+		); err != nil {
+			return nil, fmt.Errorf("add participant %s: %w", username, err)
 		}
 	}
 
@@ -128,37 +310,146 @@ func (s *Store) CreateConversation(creatorID int, payload models.CreateConversat
 		return nil, err
 	}
 
-	// After commit, if it's a DM and name is null, fetch the other participant's name
-	finalName := name
-	if !payload.IsGroup && name == nil && len(payload.Usernames) > 0 {
-		n := payload.Usernames[0]
-		finalName = &n
+	return &models.Conversation{ID: convID, Name: name, IsGroup: payload.IsGroup}, nil
+}
+
+// dmKey identifies the DM between two users regardless of who started it.
+func dmKey(a, b int) string {
+	if a > b {
+		a, b = b, a
+	}
+	return fmt.Sprintf("%d:%d", a, b)
+}
+
+// getOrCreateDM returns the one DM between the creator and the other user,
+// creating it on first use. Anyone who had left it is added back.
+func (s *Store) getOrCreateDM(creatorID int, payload models.CreateConversationPayload) (*models.Conversation, error) {
+	if len(payload.Usernames) != 1 {
+		return nil, ErrDMNeedsOneUser
+	}
+	exists, otherID := s.CheckUserExists(payload.Usernames[0])
+	if !exists {
+		return nil, &UserNotFoundError{Username: payload.Usernames[0]}
+	}
+	key := dmKey(creatorID, otherID)
+
+	var name *string
+	if payload.Name != "" {
+		name = &payload.Name
 	}
 
-	return &models.Conversation{ID: convID, Name: finalName, IsGroup: payload.IsGroup}, nil
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The unique index settles concurrent creates: the loser finds the row
+	var convID int
+	err = tx.QueryRow(`
+		INSERT INTO conversations (name, is_group, dm_key) VALUES ($1, FALSE, $2)
+		ON CONFLICT (dm_key) WHERE dm_key IS NOT NULL DO NOTHING
+		RETURNING id
+	`, name, key).Scan(&convID)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = tx.QueryRow("SELECT id FROM conversations WHERE dm_key = $1", key).Scan(&convID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, userID := range []int{creatorID, otherID} {
+		if _, err := tx.Exec(
+			"INSERT INTO conversation_participants (conversation_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+			convID, userID,
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.GetUserConversation(creatorID, convID)
 }
 
+// userConversationsSQL lists conversations as user $1 sees them, limited to
+// conversation $2 unless it is 0. DMs are named after the other
+// participant, unread counts are per user, and each row carries its latest
+// message and the user's settings. Archived conversations come last and
+// pinned ones first, in their chosen order.
+const userConversationsSQL = `
+	WITH mine AS (
+		SELECT conversation_id, COALESCE(last_read_message_id, 0) AS last_read_id,
+			muted_until, archived, pinned, sort_order
+		FROM conversation_participants
+		WHERE user_id = $1 AND ($2 = 0 OR conversation_id = $2)
+	),
+	last_msg AS (
+		SELECT DISTINCT ON (m.conversation_id)
+			m.conversation_id, m.id, m.sender_id, u.username, m.kind,
+			LEFT(m.content, 120) AS snippet, m.created_at
+		FROM messages m
+		JOIN mine ON mine.conversation_id = m.conversation_id
+		LEFT JOIN users u ON u.id = m.sender_id
+		ORDER BY m.conversation_id, m.id DESC
+	),
+	unread AS (
+		SELECT m.conversation_id, COUNT(*) AS n
+		FROM messages m
+		JOIN mine ON mine.conversation_id = m.conversation_id
+		WHERE m.id > mine.last_read_id
+		GROUP BY m.conversation_id
+	),
+	dm_name AS (
+		SELECT DISTINCT ON (cp.conversation_id) cp.conversation_id, u.username
+		FROM conversation_participants cp
+		JOIN mine ON mine.conversation_id = cp.conversation_id
+		JOIN users u ON u.id = cp.user_id
+		WHERE cp.user_id != $1
+		ORDER BY cp.conversation_id, cp.joined_at
+	)
+	SELECT
+		c.id, COALESCE(c.name, dm_name.username), c.is_group, c.created_at,
+		COALESCE(unread.n, 0),
+		last_msg.id, last_msg.sender_id, last_msg.username, last_msg.kind,
+		last_msg.snippet, last_msg.created_at, att.id, att.name,
+		mine.muted_until, mine.archived, mine.pinned, mine.sort_order
+	FROM mine
+	JOIN conversations c ON c.id = mine.conversation_id
+	LEFT JOIN dm_name ON dm_name.conversation_id = c.id
+	LEFT JOIN unread ON unread.conversation_id = c.id
+	LEFT JOIN last_msg ON last_msg.conversation_id = c.id
+	LEFT JOIN attachments att ON att.message_id = last_msg.id
+	ORDER BY mine.archived, mine.pinned DESC,
+		CASE WHEN mine.pinned THEN mine.sort_order END,
+		COALESCE(last_msg.created_at, c.created_at) DESC, c.id DESC`
+
+// GetUserConversations returns the user's conversations, most recently
+// active first.
 func (s *Store) GetUserConversations(userID int) ([]models.Conversation, error) {
-	rows, err := s.db.Query(`
-		SELECT 
-			c.id, 
-			COALESCE(c.name, (
-				SELECT u.username 
-				FROM conversation_participants cp2 
-				JOIN users u ON cp2.user_id = u.id 
-				WHERE cp2.conversation_id = c.id AND cp2.user_id != $1 
-				LIMIT 1
-			)) as name, 
-			c.is_group, 
-			c.created_at,
-			(SELECT COUNT(*) FROM messages m 
-			 WHERE m.conversation_id = c.id 
-			 AND m.created_at > cp.last_read_at) as unread_count
-		FROM conversations c
-		JOIN conversation_participants cp ON c.id = cp.conversation_id
-		WHERE cp.user_id = $1
-		ORDER BY c.created_at DESC
-	`, userID)
+	defer metrics.ObserveQuery("get_user_conversations", time.Now())
+
+	return s.queryUserConversations(userID, 0)
+}
+
+// GetUserConversation loads one conversation as the user sees it in their
+// list. sql.ErrNoRows means the user is not a participant.
+func (s *Store) GetUserConversation(userID, convID int) (*models.Conversation, error) {
+	defer metrics.ObserveQuery("get_user_conversation", time.Now())
+
+	convs, err := s.queryUserConversations(userID, convID)
+	if err != nil {
+		return nil, err
+	}
+	if len(convs) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &convs[0], nil
+}
+
+func (s *Store) queryUserConversations(userID, convID int) ([]models.Conversation, error) {
+	rows, err := s.db.Query(userConversationsSQL, userID, convID)
 	if err != nil {
 		return nil, err
 	}
@@ -167,28 +458,107 @@ func (s *Store) GetUserConversations(userID int) ([]models.Conversation, error)
 	var convs []models.Conversation
 	for rows.Next() {
 		var c models.Conversation
-		if err := rows.Scan(&c.ID, &c.Name, &c.IsGroup, &c.CreatedAt, &c.UnreadCount); err != nil {
-			log.Printf("Error scanning conversation: %v", err)
+		var (
+			msgID, senderID, fileID         sql.NullInt64
+			sender, kind, snippet, fileName sql.NullString
+			sentAt, mutedUntil              sql.NullTime
+		)
+		if err := rows.Scan(&c.ID, &c.Name, &c.IsGroup, &c.CreatedAt, &c.UnreadCount,
+			&msgID, &senderID, &sender, &kind, &snippet, &sentAt, &fileID, &fileName,
+			&mutedUntil, &c.Archived, &c.Pinned, &c.SortOrder); err != nil {
+			s.log.Error("Failed to scan conversation", "user_id", userID, "error", err)
 			continue
 		}
+		if msgID.Valid {
+			c.LastMessage = &models.Message{
+				ID:             int(msgID.Int64),
+				ConversationID: c.ID,
+				SenderID:       int(senderID.Int64),
+				SenderUsername: sender.String,
+				Kind:           kind.String,
+				Content:        snippet.String,
+				CreatedAt:      sentAt.Time,
+			}
+			if fileID.Valid {
+				c.LastMessage.Attachment = &models.Attachment{ID: int(fileID.Int64), Name: fileName.String}
+			}
+		}
+		if mutedUntil.Valid {
+			c.MutedUntil = &mutedUntil.Time
+		}
 		convs = append(convs, c)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 	return convs, nil
 }
 
-func (s *Store) AddParticipant(convID int, username string) error {
+// mutedForever stands in for "until unmuted"; it sorts after any real time.
+var mutedForever = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// UpdateConversationSettings applies the non-nil fields of the payload to
+// the user's settings. Pinning without a sort order puts the conversation
+// after the user's other pins. sql.ErrNoRows means the user is not a
+// participant.
+func (s *Store) UpdateConversationSettings(userID int, p models.ConversationSettingsPayload) error {
+	defer metrics.ObserveQuery("update_conversation_settings", time.Now())
+
+	var mutedUntil *time.Time
+	if p.MuteMinutes != nil {
+		switch m := *p.MuteMinutes; {
+		case m < 0:
+			mutedUntil = &mutedForever
+		case m > 0:
+			t := time.Now().Add(time.Duration(m) * time.Minute)
+			mutedUntil = &t
+		}
+	}
+
+	res, err := s.db.Exec(`
+		UPDATE conversation_participants cp SET
+			muted_until = CASE WHEN $3 THEN $4 ELSE cp.muted_until END,
+			archived = COALESCE($5, cp.archived),
+			sort_order = CASE
+				WHEN $7::int IS NOT NULL THEN $7
+				WHEN $6 AND NOT cp.pinned THEN (
+					SELECT COALESCE(MAX(o.sort_order), 0) + 1 FROM conversation_participants o
+					WHERE o.user_id = $1 AND o.pinned
+				)
+				ELSE cp.sort_order
+			END,
+			pinned = COALESCE($6, cp.pinned)
+		WHERE cp.user_id = $1 AND cp.conversation_id = $2
+	`, userID, p.ConversationID, p.MuteMinutes != nil, mutedUntil, p.Archived, p.Pinned, p.SortOrder)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(res)
+}
+
+// AddParticipant adds the user to the conversation; added is false if they
+// were already in it.
+func (s *Store) AddParticipant(convID int, username string) (added bool, err error) {
+	defer metrics.ObserveQuery("add_participant", time.Now())
+
 	exists, userID := s.CheckUserExists(username)
 	if !exists {
-		return fmt.Errorf("user %s not found", username)
+		return false, &UserNotFoundError{Username: username}
 	}
-	_, err := s.db.Exec(
+	res, err := s.db.Exec(
 		"INSERT INTO conversation_participants (conversation_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
 		convID, userID,
 	)
-	return err
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
 }
 
 func (s *Store) RenameConversation(convID int, newName string) error {
+	defer metrics.ObserveQuery("rename_conversation", time.Now())
+
 	var name *string
 	if newName != "" {
 		name = &newName
@@ -197,41 +567,245 @@ func (s *Store) RenameConversation(convID int, newName string) error {
 	return err
 }
 
+// LeaveConversation removes the user. When the owner of a group leaves,
+// ownership passes to the longest-standing admin, or failing that the
+// longest-standing member.
 func (s *Store) LeaveConversation(userID, convID int) error {
-	_, err := s.db.Exec("DELETE FROM conversation_participants WHERE user_id = $1 AND conversation_id = $2", userID, convID)
-	return err
+	defer metrics.ObserveQuery("leave_conversation", time.Now())
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var role string
+	err = tx.QueryRow(
+		"DELETE FROM conversation_participants WHERE user_id = $1 AND conversation_id = $2 RETURNING role",
+		userID, convID,
+	).Scan(&role)
+	if err != nil {
+		return err
+	}
+
+	if role == models.RoleOwner {
+		if _, err := tx.Exec(`
+			UPDATE conversation_participants SET role = 'owner'
+			WHERE conversation_id = $1 AND user_id = (
+				SELECT user_id FROM conversation_participants
+				WHERE conversation_id = $1
+				ORDER BY role = 'admin' DESC, joined_at, user_id
+				LIMIT 1
+			)
+		`, convID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
-func (s *Store) UpdateReadReceipt(userID, conversationID int) error {
-	_, err := s.db.Exec(`
-		UPDATE conversation_participants
-		SET last_read_at = NOW()
-		WHERE user_id = $1 AND conversation_id = $2
-	`, userID, conversationID)
-	return err
+func (s *Store) GetParticipantIDs(convID int) ([]int, error) {
+	defer metrics.ObserveQuery("get_participant_ids", time.Now())
+
+	rows, err := s.db.Query("SELECT user_id FROM conversation_participants WHERE conversation_id = $1", convID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// GetMembership returns the user's role in a conversation and whether it
+// is a group. sql.ErrNoRows means the user is not a participant.
+func (s *Store) GetMembership(convID, userID int) (role string, isGroup bool, err error) {
+	defer metrics.ObserveQuery("get_membership", time.Now())
+
+	err = s.db.QueryRow(`
+		SELECT cp.role, c.is_group
+		FROM conversation_participants cp
+		JOIN conversations c ON c.id = cp.conversation_id
+		WHERE cp.conversation_id = $1 AND cp.user_id = $2
+	`, convID, userID).Scan(&role, &isGroup)
+	return role, isGroup, err
+}
+
+func (s *Store) RemoveParticipant(convID, userID int) error {
+	defer metrics.ObserveQuery("remove_participant", time.Now())
+
+	res, err := s.db.Exec(
+		"DELETE FROM conversation_participants WHERE conversation_id = $1 AND user_id = $2",
+		convID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(res)
+}
+
+func (s *Store) SetParticipantRole(convID, userID int, role string) error {
+	defer metrics.ObserveQuery("set_participant_role", time.Now())
+
+	res, err := s.db.Exec(
+		"UPDATE conversation_participants SET role = $1 WHERE conversation_id = $2 AND user_id = $3",
+		role, convID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(res)
+}
+
+// TransferOwnership makes toID the owner and demotes the previous owner to
+// admin, atomically so a group never has zero or two owners.
+func (s *Store) TransferOwnership(convID, fromID, toID int) error {
+	defer metrics.ObserveQuery("transfer_ownership", time.Now())
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		"UPDATE conversation_participants SET role = 'admin' WHERE conversation_id = $1 AND user_id = $2 AND role = 'owner'",
+		convID, fromID,
+	)
+	if err != nil {
+		return err
+	}
+	if err := expectOneRow(res); err != nil {
+		return err
+	}
+	res, err = tx.Exec(
+		"UPDATE conversation_participants SET role = 'owner' WHERE conversation_id = $1 AND user_id = $2",
+		convID, toID,
+	)
+	if err != nil {
+		return err
+	}
+	if err := expectOneRow(res); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateReadReceipt marks the conversation read up to upTo (0 for its
+// newest message). The read position never moves backwards; advanced
+// reports whether it moved, so receipts are only sent for real progress.
+func (s *Store) UpdateReadReceipt(userID, conversationID, upTo int) (lastRead int, advanced bool, err error) {
+	defer metrics.ObserveQuery("update_read_receipt", time.Now())
+
+	var prev int
+	err = s.db.QueryRow(`
+		UPDATE conversation_participants cp
+		SET last_read_at = NOW(),
+			last_read_message_id = GREATEST(COALESCE(cp.last_read_message_id, 0), latest.id)
+		FROM (
+			SELECT COALESCE(MAX(id), 0) AS id FROM messages
+			WHERE conversation_id = $2 AND ($3 = 0 OR id <= $3)
+		) latest, (
+			SELECT COALESCE(last_read_message_id, 0) AS id FROM conversation_participants
+			WHERE user_id = $1 AND conversation_id = $2
+		) old
+		WHERE cp.user_id = $1 AND cp.conversation_id = $2
+		RETURNING cp.last_read_message_id, old.id
+	`, userID, conversationID, upTo).Scan(&lastRead, &prev)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil // Not a participant
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return lastRead, lastRead > prev, nil
+}
+
+// GetReadStates returns every participant's read position.
+func (s *Store) GetReadStates(convID int) ([]models.ReadState, error) {
+	defer metrics.ObserveQuery("get_read_states", time.Now())
+
+	rows, err := s.db.Query(`
+		SELECT u.id, u.username, COALESCE(cp.last_read_message_id, 0)
+		FROM conversation_participants cp
+		JOIN users u ON u.id = cp.user_id
+		WHERE cp.conversation_id = $1
+	`, convID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var states []models.ReadState
+	for rows.Next() {
+		var st models.ReadState
+		if err := rows.Scan(&st.UserID, &st.Username, &st.LastReadMessageID); err != nil {
+			return nil, err
+		}
+		states = append(states, st)
+	}
+	return states, rows.Err()
 }
 
 // Message Methods
+//
+// Messages are ordered by ID everywhere. IDs come from one sequence and
+// merging DMs kept them, so ID order is the order messages were sent in.
 
 func (s *Store) GetConversationMessages(convID int, limit int) ([]models.Message, error) {
+	defer metrics.ObserveQuery("get_conversation_messages", time.Now())
+
 	rows, err := s.db.Query(`
-		SELECT m.id, m.conversation_id, m.sender_id, u.username, m.content, m.created_at
+		SELECT m.id, m.conversation_id, m.sender_id, u.username, m.kind, m.content, m.created_at
 		FROM messages m
 		LEFT JOIN users u ON m.sender_id = u.id
 		WHERE m.conversation_id = $1
-		ORDER BY m.created_at DESC
+		ORDER BY m.id DESC
 		LIMIT $2
 	`, convID, limit)
 	if err != nil {
 		return nil, err
 	}
+	return s.scanMessages(rows, convID)
+}
+
+// GetMessagesAfter returns the newest messages with an ID above afterID,
+// for clients that have everything up to it cached, which are exactly the
+// messages sent since.
+func (s *Store) GetMessagesAfter(convID, afterID, limit int) ([]models.Message, error) {
+	defer metrics.ObserveQuery("get_messages_after", time.Now())
+
+	rows, err := s.db.Query(`
+		SELECT m.id, m.conversation_id, m.sender_id, u.username, m.kind, m.content, m.created_at
+		FROM messages m
+		LEFT JOIN users u ON m.sender_id = u.id
+		WHERE m.conversation_id = $1 AND m.id > $2
+		ORDER BY m.id DESC
+		LIMIT $3
+	`, convID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return s.scanMessages(rows, convID)
+}
+
+// scanMessages reads rows newest first and returns them oldest first.
+func (s *Store) scanMessages(rows *sql.Rows, convID int) ([]models.Message, error) {
 	defer rows.Close()
 
 	var msgs []models.Message
 	for rows.Next() {
 		var m models.Message
 		var senderUsername sql.NullString
-		if err := rows.Scan(&m.ID, &m.ConversationID, &m.SenderID, &senderUsername, &m.Content, &m.CreatedAt); err != nil {
+		if err := rows.Scan(&m.ID, &m.ConversationID, &m.SenderID, &senderUsername, &m.Kind, &m.Content, &m.CreatedAt); err != nil {
+			s.log.Error("Failed to scan message", "conversation_id", convID, "error", err)
 			continue
 		}
 		if senderUsername.Valid {
@@ -239,6 +813,9 @@ func (s *Store) GetConversationMessages(convID int, limit int) ([]models.Message
 		}
 		msgs = append(msgs, m)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
 	// Reverse to get oldest first
 	for i, j := 0, len(msgs)-1; i < j; i, j = i+1, j-1 {
@@ -247,13 +824,68 @@ func (s *Store) GetConversationMessages(convID int, limit int) ([]models.Message
 	return msgs, nil
 }
 
-func (s *Store) SaveMessage(convID, senderID int, content string) (*models.Message, error) {
+// SaveMessage stores a user's message. A clientID the sender already used
+// returns the stored message with created false, so clients can resend
+// after losing the connection without duplicating it.
+func (s *Store) SaveMessage(convID, senderID int, content, clientID string) (msg *models.Message, created bool, err error) {
+	defer metrics.ObserveQuery("save_message", time.Now())
+
+	msg, err = s.saveMessage(convID, senderID, models.MessageText, content, clientID)
+	if !errors.Is(err, sql.ErrNoRows) || clientID == "" {
+		return msg, err == nil, err
+	}
+	msg, err = s.GetSentMessage(senderID, clientID)
+	return msg, false, err
+}
+
+// GetSentMessage returns the message the sender stored under clientID,
+// with its attachment if it shared a file.
+func (s *Store) GetSentMessage(senderID int, clientID string) (*models.Message, error) {
+	defer metrics.ObserveQuery("get_sent_message", time.Now())
+
+	var m models.Message
+	var attID sql.NullInt64
+	var a models.Attachment
+	var name, mimeType, sum sql.NullString
+	var size sql.NullInt64
+	var attCreated sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT m.id, m.conversation_id, m.sender_id, COALESCE(u.username, ''), m.kind, m.content, m.created_at,
+			a.id, a.name, a.mime_type, a.size, a.sha256, a.created_at
+		FROM messages m
+		LEFT JOIN users u ON u.id = m.sender_id
+		LEFT JOIN attachments a ON a.message_id = m.id
+		WHERE m.sender_id = $1 AND m.client_id = $2
+	`, senderID, clientID).Scan(&m.ID, &m.ConversationID, &m.SenderID, &m.SenderUsername, &m.Kind, &m.Content, &m.CreatedAt,
+		&attID, &name, &mimeType, &size, &sum, &attCreated)
+	if err != nil {
+		return nil, err
+	}
+	if attID.Valid {
+		a.ID = int(attID.Int64)
+		a.Name, a.MimeType, a.Size, a.SHA256, a.CreatedAt = name.String, mimeType.String, size.Int64, sum.String, attCreated.Time
+		m.Attachment = &a
+	}
+	return &m, nil
+}
+
+// SaveSystemMessage records a membership or name change, attributed to
+// the user who caused it.
+func (s *Store) SaveSystemMessage(convID, actorID int, kind, content string) (*models.Message, error) {
+	defer metrics.ObserveQuery("save_system_message", time.Now())
+
+	return s.saveMessage(convID, actorID, kind, content, "")
+}
+
+// saveMessage returns sql.ErrNoRows if the sender already used clientID.
+func (s *Store) saveMessage(convID, senderID int, kind, content, clientID string) (*models.Message, error) {
 	var msg models.Message
 	err := s.db.QueryRow(`
-		INSERT INTO messages (conversation_id, sender_id, content)
-		VALUES ($1, $2, $3)
-		RETURNING id, conversation_id, sender_id, content, created_at
-	`, convID, senderID, content).Scan(&msg.ID, &msg.ConversationID, &msg.SenderID, &msg.Content, &msg.CreatedAt)
+		INSERT INTO messages (conversation_id, sender_id, kind, content, client_id)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+		ON CONFLICT (sender_id, client_id) WHERE client_id IS NOT NULL DO NOTHING
+		RETURNING id, conversation_id, sender_id, kind, content, created_at
+	`, convID, senderID, kind, content, clientID).Scan(&msg.ID, &msg.ConversationID, &msg.SenderID, &msg.Kind, &msg.Content, &msg.CreatedAt)
 	if err != nil {
 		return nil, err
 	}
@@ -261,8 +893,130 @@ func (s *Store) SaveMessage(convID, senderID int, content string) (*models.Messa
 	// Get sender username
 	// Optimization: we could pass the username to avoid a query, but this is safer
 	user, err := s.GetUserByID(senderID)
-	if err == nil {
+	if err != nil {
+		s.log.Warn("Failed to load sender for message", "message_id", msg.ID, "sender_id", senderID, "error", err)
+	} else {
 		msg.SenderUsername = user.Username
 	}
 	return &msg, nil
 }
+
+// Delivery Methods
+
+// markDeliveredSQL records deliveries to user $1 for the messages matched by
+// the caller's condition, skipping their own messages, system events and
+// conversations they are not in. Only new rows are returned.
+const markDeliveredSQL = `
+	WITH ins AS (
+		INSERT INTO message_deliveries (message_id, user_id)
+		SELECT m.id, $1
+		FROM messages m
+		JOIN conversation_participants cp ON cp.conversation_id = m.conversation_id AND cp.user_id = $1
+		WHERE m.sender_id IS DISTINCT FROM $1 AND m.kind = 'text' AND %s
+		ON CONFLICT DO NOTHING
+		RETURNING message_id
+	)
+	SELECT m.id, m.conversation_id, m.sender_id
+	FROM ins
+	JOIN messages m ON m.id = ins.message_id`
+
+// MarkDelivered records that the user's device received the messages.
+func (s *Store) MarkDelivered(userID int, messageIDs []int) ([]models.Delivery, error) {
+	defer metrics.ObserveQuery("mark_delivered", time.Now())
+
+	return s.markDelivered(fmt.Sprintf(markDeliveredSQL, "m.id = ANY($2)"), userID, pq.Array(messageIDs))
+}
+
+// MarkConversationDelivered records delivery of everything in a
+// conversation, for when the user loads its history.
+func (s *Store) MarkConversationDelivered(userID, convID int) ([]models.Delivery, error) {
+	defer metrics.ObserveQuery("mark_conversation_delivered", time.Now())
+
+	return s.markDelivered(fmt.Sprintf(markDeliveredSQL, "m.conversation_id = $2"), userID, convID)
+}
+
+func (s *Store) markDelivered(query string, args ...interface{}) ([]models.Delivery, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ds []models.Delivery
+	for rows.Next() {
+		var d models.Delivery
+		var senderID sql.NullInt64
+		if err := rows.Scan(&d.MessageID, &d.ConversationID, &senderID); err != nil {
+			return nil, err
+		}
+		d.SenderID = int(senderID.Int64)
+		ds = append(ds, d)
+	}
+	return ds, rows.Err()
+}
+
+// GetDeliveries maps each of the sender's messages in a conversation, from
+// sinceID on, to the recipients that have received it.
+func (s *Store) GetDeliveries(convID, senderID, sinceID int) (map[int][]int, error) {
+	defer metrics.ObserveQuery("get_deliveries", time.Now())
+
+	rows, err := s.db.Query(`
+		SELECT d.message_id, d.user_id
+		FROM message_deliveries d
+		JOIN messages m ON m.id = d.message_id
+		WHERE m.conversation_id = $1 AND m.sender_id = $2 AND m.id >= $3
+	`, convID, senderID, sinceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := make(map[int][]int)
+	for rows.Next() {
+		var msgID, userID int
+		if err := rows.Scan(&msgID, &userID); err != nil {
+			return nil, err
+		}
+		deliveries[msgID] = append(deliveries[msgID], userID)
+	}
+	return deliveries, rows.Err()
+}
+
+// SaveMentions records the participants mentioned in a message.
+func (s *Store) SaveMentions(messageID int, userIDs []int) error {
+	defer metrics.ObserveQuery("save_mentions", time.Now())
+
+	_, err := s.db.Exec(`
+		INSERT INTO message_mentions (message_id, user_id)
+		SELECT $1, unnest($2::int[])
+		ON CONFLICT DO NOTHING
+	`, messageID, pq.Array(userIDs))
+	return err
+}
+
+// GetMentions returns who is mentioned in each message of a conversation
+// from sinceID on.
+func (s *Store) GetMentions(convID, sinceID int) (map[int][]int, error) {
+	defer metrics.ObserveQuery("get_mentions", time.Now())
+
+	rows, err := s.db.Query(`
+		SELECT mm.message_id, mm.user_id
+		FROM message_mentions mm
+		JOIN messages m ON m.id = mm.message_id
+		WHERE m.conversation_id = $1 AND m.id >= $2
+	`, convID, sinceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mentions := make(map[int][]int)
+	for rows.Next() {
+		var msgID, userID int
+		if err := rows.Scan(&msgID, &userID); err != nil {
+			return nil, err
+		}
+		mentions[msgID] = append(mentions[msgID], userID)
+	}
+	return mentions, rows.Err()
+}
@@ -0,0 +1,284 @@
+package ws
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+
+	"github.com/cloudzz-dev/cldzmsg/internal/server/attachments"
+	"github.com/cloudzz-dev/cldzmsg/internal/server/metrics"
+	"github.com/cloudzz-dev/cldzmsg/internal/server/models"
+)
+
+// Files travel in base64 chunks of at most attachmentChunkSize bytes,
+// each acknowledged before the next is sent, so a transfer never holds
+// more than one chunk in a connection's buffers.
+const (
+	attachmentChunkSize = 48 * 1024
+	maxUploadsPerClient = 4
+)
+
+// upload is a file a connection is sending, with the message to post once
+// it is complete.
+type upload struct {
+	*attachments.Upload
+	conversationID int
+	name           string
+	caption        string
+	clientID       string
+}
+
+// handleUploadStart checks a file fits the limits and quotas and replies
+// with the ID to send its chunks under.
+func (c *Client) handleUploadStart(p models.UploadStartPayload) {
+	fail := func(msg string) {
+		c.SendJSON(map[string]interface{}{
+			"type":      "upload_failed",
+			"client_id": p.ClientID,
+			"error":     msg,
+		})
+	}
+	if c.Hub.Files == nil {
+		fail("File sharing is disabled on this server")
+		return
+	}
+	if !c.isMember(p.ConversationID) {
+		fail(errNotMember.Error())
+		return
+	}
+	if len(p.ClientID) > 64 {
+		p.ClientID = ""
+	}
+	// A retry of an upload that already went through needs no data
+	if p.ClientID != "" {
+		msg, err := c.Hub.Store.GetSentMessage(c.UserID, p.ClientID)
+		if err == nil {
+			msg.ClientID = p.ClientID
+			c.echoMessage(msg)
+			return
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			c.log.Error("Failed to look up message", "client_id", p.ClientID, "error", err)
+		}
+	}
+	if len(c.uploads) >= maxUploadsPerClient {
+		fail("Too many uploads at once, wait for one to finish")
+		return
+	}
+
+	userUsed, totalUsed, err := c.Hub.Store.AttachmentUsage(c.UserID)
+	if err != nil {
+		c.log.Error("Failed to load attachment usage", "error", err)
+		fail("Upload failed")
+		return
+	}
+	up, err := c.Hub.Files.Begin(c.UserID, p.Size, userUsed, totalUsed)
+	if err != nil {
+		c.log.Info("Upload rejected", "size", p.Size, "error", err)
+		fail("Upload rejected: " + err.Error())
+		return
+	}
+	if c.uploads == nil {
+		c.uploads = make(map[string]*upload)
+	}
+	c.uploads[up.ID] = &upload{
+		Upload:         up,
+		conversationID: p.ConversationID,
+		name:           attachments.CleanName(p.Name),
+		caption:        p.Caption,
+		clientID:       p.ClientID,
+	}
+	c.SendJSON(map[string]interface{}{
+		"type":       "upload_ready",
+		"upload_id":  up.ID,
+		"client_id":  p.ClientID,
+		"chunk_size": attachmentChunkSize,
+	})
+}
+
+// handleUploadChunk stores the next chunk of an upload and, with the last
+// one, posts the message.
+func (c *Client) handleUploadChunk(p models.UploadChunkPayload) {
+	up := c.uploads[p.UploadID]
+	if up == nil {
+		c.SendJSON(map[string]interface{}{
+			"type":      "upload_failed",
+			"upload_id": p.UploadID,
+			"error":     "Unknown upload",
+		})
+		return
+	}
+	if len(p.Data) > attachmentChunkSize {
+		c.abortUpload(up, "Chunk too large")
+		return
+	}
+	if err := up.Write(p.Offset, p.Data); err != nil {
+		c.log.Warn("Upload chunk rejected", "upload_id", up.ID, "offset", p.Offset, "error", err)
+		c.abortUpload(up, "Upload failed: "+err.Error())
+		return
+	}
+	if !up.Done() {
+		c.SendJSON(map[string]interface{}{
+			"type":      "upload_progress",
+			"upload_id": up.ID,
+			"client_id": up.clientID,
+			"received":  up.Received,
+		})
+		return
+	}
+	c.finishUpload(up)
+}
+
+func (c *Client) finishUpload(up *upload) {
+	delete(c.uploads, up.ID)
+	defer up.Close()
+
+	// Membership may have changed while the file was arriving
+	if !c.isMember(up.conversationID) {
+		c.sendUploadFailed(up, errNotMember.Error())
+		return
+	}
+	key, sum, err := up.Finish()
+	if err != nil {
+		c.log.Error("Failed to store upload", "upload_id", up.ID, "error", err)
+		c.sendUploadFailed(up, "Upload failed")
+		return
+	}
+	msg, created, err := c.Hub.Store.SaveAttachment(up.conversationID, c.UserID, up.caption, up.clientID, &models.Attachment{
+		Name:       up.name,
+		MimeType:   up.MimeType(),
+		Size:       up.Size,
+		SHA256:     sum,
+		StorageKey: key,
+	})
+	if err != nil {
+		c.log.Error("Failed to save attachment", "conversation_id", up.conversationID, "error", err)
+		if err := c.Hub.Files.Remove(key); err != nil {
+			c.log.Error("Failed to remove unsaved upload", "key", key, "error", err)
+		}
+		c.sendUploadFailed(up, "Upload failed")
+		return
+	}
+	msg.ClientID = up.clientID
+	if !created {
+		// Sent twice at once: keep the first copy, so it counts once towards the quota
+		if err := c.Hub.Files.Remove(key); err != nil {
+			c.log.Error("Failed to remove duplicate upload", "key", key, "error", err)
+		}
+		c.echoMessage(msg)
+		return
+	}
+	c.log.Info("File shared", "conversation_id", up.conversationID, "attachment_id", msg.Attachment.ID, "size", up.Size)
+	metrics.MessagesSent.Inc()
+	c.recordMentions(msg)
+	c.broadcastMessage(msg)
+}
+
+// handleUploadCancel drops an upload the client gave up on.
+func (c *Client) handleUploadCancel(uploadID string) {
+	if up := c.uploads[uploadID]; up != nil {
+		delete(c.uploads, uploadID)
+		up.Close()
+	}
+}
+
+func (c *Client) abortUpload(up *upload, msg string) {
+	delete(c.uploads, up.ID)
+	up.Close()
+	c.sendUploadFailed(up, msg)
+}
+
+func (c *Client) sendUploadFailed(up *upload, msg string) {
+	c.SendJSON(map[string]interface{}{
+		"type":      "upload_failed",
+		"upload_id": up.ID,
+		"client_id": up.clientID,
+		"error":     msg,
+	})
+}
+
+// closeUploads discards unfinished uploads when the connection ends.
+func (c *Client) closeUploads() {
+	for id, up := range c.uploads {
+		delete(c.uploads, id)
+		up.Close()
+	}
+}
+
+// handleDownload sends one chunk of an attachment to a member of the
+// conversation it was shared in. Clients ask for the next offset until
+// they have the whole file.
+func (c *Client) handleDownload(p models.DownloadPayload) {
+	fail := func(msg string) {
+		c.SendJSON(map[string]interface{}{
+			"type":          "download_failed",
+			"attachment_id": p.AttachmentID,
+			"error":         msg,
+		})
+	}
+	if c.Hub.Files == nil {
+		fail("File sharing is disabled on this server")
+		return
+	}
+	a, convID, err := c.Hub.Store.GetAttachment(p.AttachmentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		fail("Attachment not found")
+		return
+	}
+	if err != nil {
+		c.log.Error("Failed to load attachment", "attachment_id", p.AttachmentID, "error", err)
+		fail("Download failed")
+		return
+	}
+	if !c.isMember(convID) {
+		fail(errNotMember.Error())
+		return
+	}
+	if p.Offset < 0 || p.Offset >= a.Size {
+		fail("Invalid offset")
+		return
+	}
+
+	f, err := c.Hub.Files.Open(a.StorageKey)
+	if err != nil {
+		c.log.Error("Failed to open attachment", "attachment_id", a.ID, "error", err)
+		fail("Download failed")
+		return
+	}
+	defer f.Close()
+	buf := make([]byte, min(attachmentChunkSize, a.Size-p.Offset))
+	n, err := f.ReadAt(buf, p.Offset)
+	if err != nil && !(errors.Is(err, io.EOF) && n == len(buf)) {
+		c.log.Error("Failed to read attachment", "attachment_id", a.ID, "error", err)
+		fail("Download failed")
+		return
+	}
+	c.SendJSON(map[string]interface{}{
+		"type":          "attachment_chunk",
+		"attachment_id": a.ID,
+		"offset":        p.Offset,
+		"size":          a.Size,
+		"data":          buf[:n],
+	})
+}
+
+// isMember checks the caller is in a conversation. Unlike membership it
+// sends no error frame, as transfers report failures their own way.
+func (c *Client) isMember(convID int) bool {
+	_, _, err := c.Hub.Store.GetMembership(convID, c.UserID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		c.log.Error("Failed to load membership", "conversation_id", convID, "error", err)
+	}
+	return err == nil
+}
+
+func (c *Client) attachFiles(convID, since int, msgs []models.Message) {
+	files, err := c.Hub.Store.GetAttachments(convID, since)
+	if err != nil {
+		c.log.Error("Failed to load attachments", "conversation_id", convID, "error", err)
+		return
+	}
+	for i := range msgs {
+		msgs[i].Attachment = files[msgs[i].ID]
+	}
+}
@@ -1,13 +1,38 @@
 package ws
 
 import (
+	"database/sql"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
 
+	"github.com/cloudzz-dev/cldzmsg/internal/server/metrics"
 	"github.com/cloudzz-dev/cldzmsg/internal/server/models"
 	"github.com/cloudzz-dev/cldzmsg/internal/server/ratelimit"
+	"github.com/cloudzz-dev/cldzmsg/internal/server/storage"
 	"github.com/gorilla/websocket"
-	"golang.org/x/crypto/bcrypt"
+)
+
+// messagePageSize is how many messages get_messages returns at most.
+const messagePageSize = 100
+
+// Codes sent with auth_error. Only the first two mean the credentials
+// themselves were refused; clients keep saved logins for the others.
+const (
+	authInvalidCredentials = "invalid_credentials"
+	authAccountDisabled    = "account_disabled"
+	authRateLimited        = "rate_limited"
+	authUsernameTaken      = "username_taken"
+	authFailed             = "error"
+)
+
+var (
+	errInvalidCredentials = errors.New("invalid username or password")
+	errAccountDisabled    = errors.New("this account has been disabled")
+	errAuthFailed         = errors.New("authentication failed, please try again")
 )
 
 type Client struct {
@@ -18,10 +43,36 @@ type Client struct {
 	Username string
 	IP       string
 	Limiter  *ratelimit.RateLimiter
+	Logger   *slog.Logger // Connection-scoped: conn_id, ip and, once known, user
+
+	// Logger for the action currently being processed
+	log *slog.Logger
+
+	// Set after a correct password when the account requires a TOTP code
+	pendingUser *models.User
+
+	// Files this connection is sending, by upload ID. Only touched from
+	// ReadPump's goroutine.
+	uploads map[string]*upload
+
+	sendMu     sync.Mutex
+	sendClosed bool
+}
+
+// closeSend closes the Send channel exactly once; a slow-client drop, a
+// forced logout and the final unregister may all try to close it.
+func (c *Client) closeSend() {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if !c.sendClosed {
+		c.sendClosed = true
+		close(c.Send)
+	}
 }
 
 func (c *Client) ReadPump() {
 	defer func() {
+		c.closeUploads()
 		c.Hub.Unregister <- c
 		c.Conn.Close()
 	}()
@@ -29,12 +80,17 @@ func (c *Client) ReadPump() {
 	for {
 		_, msgBytes, err := c.Conn.ReadMessage()
 		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				c.Logger.Info("Connection closed unexpectedly", "error", err)
+			} else {
+				c.Logger.Debug("Connection closed", "error", err)
+			}
 			break
 		}
 
 		var wsMsg models.WSMessage
 		if err := json.Unmarshal(msgBytes, &wsMsg); err != nil {
-			log.Printf("JSON Unmarshal error: %v", err)
+			c.Logger.Warn("Invalid message frame", "error", err, "bytes", len(msgBytes))
 			continue
 		}
 
@@ -47,38 +103,109 @@ func (c *Client) WritePump() {
 		c.Conn.Close()
 	}()
 	for msg := range c.Send {
-		c.Conn.WriteMessage(websocket.TextMessage, msg)
+		if err := c.Conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			return // ReadPump notices the broken connection and logs it
+		}
 	}
 }
 
 func (c *Client) ProcessMessage(msg models.WSMessage) {
+	// Unknown types share one label so clients can't blow up cardinality
+	start := time.Now()
+	action := msg.Type
+	c.log = c.Logger.With("action", msg.Type)
+	defer func() {
+		elapsed := time.Since(start)
+		metrics.ActionDuration.WithLabelValues(action).Observe(elapsed.Seconds())
+		c.log.Debug("Handled action", "duration_ms", elapsed.Milliseconds())
+	}()
+
 	switch msg.Type {
 	case "auth":
 		if !c.Limiter.CanAuth(c.IP) {
-			c.SendError("auth_error", "Too many login attempts. Please wait a minute.")
+			metrics.RateLimitRejections.WithLabelValues("auth").Inc()
+			c.sendAuthError(authRateLimited, "Too many login attempts. Please wait a minute.")
 			return
 		}
 
 		var payload models.AuthPayload
-		json.Unmarshal(msg.Payload, &payload)
+		if !c.decode(msg.Payload, &payload) {
+			return
+		}
 
-		userID, username, err := c.handleAuth(payload)
+		user, err := c.handleAuth(payload)
 		if err != nil {
-			c.SendError("auth_error", err.Error())
+			c.log.Info("Authentication failed", "username", payload.Username, "auth_action", payload.Action, "error", err)
+			c.sendAuthError(authErrorCode(err), err.Error())
 			return
 		}
 
-		c.UserID = userID
-		c.Username = username
-		c.Hub.Register <- c
+		// Second step: hold the user until a valid code arrives
+		if user.TOTPEnabled {
+			c.pendingUser = user
+			c.SendJSON(map[string]interface{}{
+				"type":     "auth_challenge",
+				"method":   "totp",
+				"username": user.Username,
+			})
+			return
+		}
 
-		convs, _ := c.Hub.Store.GetUserConversations(userID)
-		c.SendJSON(map[string]interface{}{
-			"type":          "auth_success",
-			"user_id":       userID,
-			"username":      username,
-			"conversations": convs,
-		})
+		c.completeAuth(user)
+
+	case "auth_totp":
+		if c.pendingUser == nil {
+			return
+		}
+		if !c.Limiter.CanAuth(c.IP) {
+			metrics.RateLimitRejections.WithLabelValues("auth").Inc()
+			c.sendAuthError(authRateLimited, "Too many login attempts. Please wait a minute.")
+			return
+		}
+		var payload models.TOTPCodePayload
+		if !c.decode(msg.Payload, &payload) {
+			return
+		}
+
+		if !c.verifySecondFactor(c.pendingUser, payload.Code) {
+			c.log.Info("Second factor rejected", "user_id", c.pendingUser.ID)
+			c.SendJSON(map[string]interface{}{
+				"type":   "auth_challenge",
+				"method": "totp",
+				"error":  "Invalid verification code",
+			})
+			return
+		}
+
+		user := c.pendingUser
+		c.pendingUser = nil
+		c.completeAuth(user)
+
+	case "totp_setup":
+		if c.UserID == 0 {
+			return
+		}
+		c.handleTOTPSetup()
+
+	case "totp_confirm":
+		if c.UserID == 0 {
+			return
+		}
+		var payload models.TOTPCodePayload
+		if !c.decode(msg.Payload, &payload) {
+			return
+		}
+		c.handleTOTPConfirm(payload.Code)
+
+	case "totp_disable":
+		if c.UserID == 0 {
+			return
+		}
+		var payload models.TOTPCodePayload
+		if !c.decode(msg.Payload, &payload) {
+			return
+		}
+		c.handleTOTPDisable(payload.Code)
 
 	case "typing":
 		if c.UserID == 0 {
@@ -87,18 +214,30 @@ func (c *Client) ProcessMessage(msg models.WSMessage) {
 		var payload struct {
 			ConversationID int `json:"conversation_id"`
 		}
-		json.Unmarshal(msg.Payload, &payload)
+		if !c.decode(msg.Payload, &payload) {
+			return
+		}
 
-		c.Hub.Broadcast <- c.MarshalJSON(map[string]interface{}{
+		ids, err := c.Hub.Store.GetParticipantIDs(payload.ConversationID)
+		if err != nil {
+			c.log.Error("Failed to load participants", "conversation_id", payload.ConversationID, "error", err)
+			return
+		}
+		if !slices.Contains(ids, c.UserID) {
+			return
+		}
+		c.Hub.SendToUsers(ids, c.marshal(map[string]interface{}{
 			"type":            "typing",
 			"conversation_id": payload.ConversationID,
 			"user_id":         c.UserID,
 			"username":        c.Username,
-		})
+		}))
 
 	case "check_user":
 		var payload models.CheckUserPayload
-		json.Unmarshal(msg.Payload, &payload)
+		if !c.decode(msg.Payload, &payload) {
+			return
+		}
 		exists, _ := c.Hub.Store.CheckUserExists(payload.Username)
 		c.SendJSON(map[string]interface{}{
 			"type":     "user_check_result",
@@ -111,33 +250,91 @@ func (c *Client) ProcessMessage(msg models.WSMessage) {
 			return
 		}
 		var payload models.CreateConversationPayload
-		json.Unmarshal(msg.Payload, &payload)
+		if !c.decode(msg.Payload, &payload) {
+			return
+		}
 		conv, err := c.Hub.Store.CreateConversation(c.UserID, payload)
-		if err != nil {
+		if isUserError(err) {
 			c.SendError("error", err.Error())
 			return
 		}
+		if err != nil {
+			c.log.Error("Failed to create conversation", "is_group", payload.IsGroup, "usernames", payload.Usernames, "error", err)
+			c.SendError("error", "Failed to create conversation")
+			return
+		}
 		c.SendJSON(map[string]interface{}{
 			"type":         "conversation_created",
 			"conversation": conv,
 		})
 
+		// Other participants, and the creator's other devices; this
+		// connection has it from conversation_created
+		ids, err := c.Hub.Store.GetParticipantIDs(conv.ID)
+		if err != nil {
+			c.log.Error("Failed to load participants", "conversation_id", conv.ID, "error", err)
+			return
+		}
+		c.pushConversationExcept(c, conversationAdded, conv.ID, ids...)
+
 	case "get_messages":
 		if c.UserID == 0 {
 			return
 		}
 		var payload struct {
 			ConversationID int `json:"conversation_id"`
+			AfterID        int `json:"after_id,omitempty"` // Newest message the client has cached
+		}
+		if !c.decode(msg.Payload, &payload) {
+			return
+		}
+		if _, _, ok := c.membership(payload.ConversationID); !ok {
+			return
 		}
-		json.Unmarshal(msg.Payload, &payload)
 
-		c.Hub.Store.UpdateReadReceipt(c.UserID, payload.ConversationID)
+		c.markRead(payload.ConversationID, 0)
+		if ds, err := c.Hub.Store.MarkConversationDelivered(c.UserID, payload.ConversationID); err != nil {
+			c.log.Error("Failed to record deliveries", "conversation_id", payload.ConversationID, "error", err)
+		} else {
+			c.notifyDelivered(ds)
+		}
 
-		msgs, _ := c.Hub.Store.GetConversationMessages(payload.ConversationID, 100)
+		// An incremental fetch that hits the limit leaves a gap, so the
+		// client is told to drop what it has cached instead of merging
+		var msgs []models.Message
+		var err error
+		complete := true
+		if payload.AfterID > 0 {
+			msgs, err = c.Hub.Store.GetMessagesAfter(payload.ConversationID, payload.AfterID, messagePageSize+1)
+			if len(msgs) > messagePageSize {
+				msgs = msgs[1:]
+				complete = false
+			}
+		} else {
+			msgs, err = c.Hub.Store.GetConversationMessages(payload.ConversationID, messagePageSize)
+		}
+		if err != nil {
+			c.log.Error("Failed to load messages", "conversation_id", payload.ConversationID, "error", err)
+		}
+		if len(msgs) > 0 {
+			// Pages are oldest first, so the first ID bounds every lookup
+			since := msgs[0].ID
+			c.attachDeliveries(payload.ConversationID, since, msgs)
+			c.attachMentions(payload.ConversationID, since, msgs)
+			c.attachFiles(payload.ConversationID, since, msgs)
+		}
+		// Lets the client work out "seen by" for each message
+		states, err := c.Hub.Store.GetReadStates(payload.ConversationID)
+		if err != nil {
+			c.log.Error("Failed to load read states", "conversation_id", payload.ConversationID, "error", err)
+		}
 		c.SendJSON(map[string]interface{}{
 			"type":            "messages",
 			"conversation_id": payload.ConversationID,
+			"after_id":        payload.AfterID,
+			"complete":        complete,
 			"messages":        msgs,
+			"read_states":     states,
 		})
 
 	case "read_receipt":
@@ -145,54 +342,157 @@ func (c *Client) ProcessMessage(msg models.WSMessage) {
 			return
 		}
 		var payload models.ReadReceiptPayload
-		json.Unmarshal(msg.Payload, &payload)
-		c.Hub.Store.UpdateReadReceipt(c.UserID, payload.ConversationID)
+		if !c.decode(msg.Payload, &payload) || !c.isMember(payload.ConversationID) {
+			return
+		}
+		c.markRead(payload.ConversationID, payload.MessageID)
+
+	case "message_ack":
+		if c.UserID == 0 {
+			return
+		}
+		var payload models.MessageAckPayload
+		if !c.decode(msg.Payload, &payload) || len(payload.MessageIDs) == 0 {
+			return
+		}
+		// Carries no conversation: markDeliveredSQL skips messages of
+		// conversations the caller isn't in
+		ds, err := c.Hub.Store.MarkDelivered(c.UserID, payload.MessageIDs)
+		if err != nil {
+			c.log.Error("Failed to record deliveries", "error", err)
+			return
+		}
+		c.notifyDelivered(ds)
 
 	case "send_message":
 		if c.UserID == 0 {
 			return
 		}
 		var payload models.SendMessagePayload
-		json.Unmarshal(msg.Payload, &payload)
-		msg, err := c.Hub.Store.SaveMessage(payload.ConversationID, c.UserID, payload.Content)
+		if !c.decode(msg.Payload, &payload) {
+			return
+		}
+		if len(payload.ClientID) > 64 {
+			payload.ClientID = "" // Not worth remembering
+		}
+		if !c.isMember(payload.ConversationID) {
+			c.SendJSON(map[string]interface{}{
+				"type":      "message_failed",
+				"client_id": payload.ClientID,
+				"error":     errNotMember.Error(),
+			})
+			return
+		}
+		msg, created, err := c.Hub.Store.SaveMessage(payload.ConversationID, c.UserID, payload.Content, payload.ClientID)
 		if err != nil {
+			c.log.Error("Failed to save message", "conversation_id", payload.ConversationID, "error", err)
+			c.SendJSON(map[string]interface{}{
+				"type":      "message_failed",
+				"client_id": payload.ClientID,
+				"error":     "Message could not be sent",
+			})
+			return
+		}
+		msg.ClientID = payload.ClientID
+		if !created {
+			c.echoMessage(msg)
 			return
 		}
+		metrics.MessagesSent.Inc()
+		c.recordMentions(msg)
+		c.broadcastMessage(msg)
 
-		c.Hub.Broadcast <- c.MarshalJSON(map[string]interface{}{
-			"type":    "new_message",
-			"message": msg,
-		})
+	case "upload_start":
+		if c.UserID == 0 {
+			return
+		}
+		var payload models.UploadStartPayload
+		if !c.decode(msg.Payload, &payload) {
+			return
+		}
+		c.handleUploadStart(payload)
+
+	case "upload_chunk":
+		if c.UserID == 0 {
+			return
+		}
+		var payload models.UploadChunkPayload
+		if !c.decode(msg.Payload, &payload) {
+			return
+		}
+		c.handleUploadChunk(payload)
+
+	case "upload_cancel":
+		if c.UserID == 0 {
+			return
+		}
+		var payload struct {
+			UploadID string `json:"upload_id"`
+		}
+		if !c.decode(msg.Payload, &payload) {
+			return
+		}
+		c.handleUploadCancel(payload.UploadID)
+
+	case "download_attachment":
+		if c.UserID == 0 {
+			return
+		}
+		var payload models.DownloadPayload
+		if !c.decode(msg.Payload, &payload) {
+			return
+		}
+		c.handleDownload(payload)
 
 	case "get_conversations":
 		if c.UserID == 0 {
 			return
 		}
-		convs, _ := c.Hub.Store.GetUserConversations(c.UserID)
-		c.SendJSON(map[string]interface{}{
-			"type":          "conversations",
-			"conversations": convs,
-		})
+		c.sendConversations()
 
 	case "add_participant":
+		if c.UserID == 0 {
+			return
+		}
+		var payload models.ParticipantPayload
+		if !c.decode(msg.Payload, &payload) {
+			return
+		}
+		c.handleAddParticipant(payload)
+
+	case "remove_participant":
+		if c.UserID == 0 {
+			return
+		}
+		var payload models.ParticipantPayload
+		if !c.decode(msg.Payload, &payload) {
+			return
+		}
+		c.handleRemoveParticipant(payload)
+
+	case "promote", "demote", "transfer_ownership":
+		if c.UserID == 0 {
+			return
+		}
+		var payload models.ParticipantPayload
+		if !c.decode(msg.Payload, &payload) {
+			return
+		}
+		c.handleRoleChange(msg.Type, payload)
+
+	case "get_conversation_info":
 		if c.UserID == 0 {
 			return
 		}
 		var payload struct {
-			ConversationID int    `json:"conversation_id"`
-			Username       string `json:"username"`
+			ConversationID int `json:"conversation_id"`
 		}
-		json.Unmarshal(msg.Payload, &payload)
-		err := c.Hub.Store.AddParticipant(payload.ConversationID, payload.Username)
-		if err != nil {
-			c.SendError("error", err.Error())
+		if !c.decode(msg.Payload, &payload) {
 			return
 		}
-		// Refresh
-		convs, _ := c.Hub.Store.GetUserConversations(c.UserID)
-		c.SendJSON(map[string]interface{}{
-			"type": "conversations", "conversations": convs,
-		})
+		if _, _, ok := c.membership(payload.ConversationID); ok {
+			c.sendConversationInfo(payload.ConversationID)
+		}
 
 	case "rename_conversation":
 		if c.UserID == 0 {
@@ -202,13 +502,10 @@ func (c *Client) ProcessMessage(msg models.WSMessage) {
 			ConversationID int    `json:"conversation_id"`
 			Name           string `json:"name"`
 		}
-		json.Unmarshal(msg.Payload, &payload)
-		c.Hub.Store.RenameConversation(payload.ConversationID, payload.Name)
-		// Refresh
-		convs, _ := c.Hub.Store.GetUserConversations(c.UserID)
-		c.SendJSON(map[string]interface{}{
-			"type": "conversations", "conversations": convs,
-		})
+		if !c.decode(msg.Payload, &payload) {
+			return
+		}
+		c.handleRename(payload.ConversationID, payload.Name)
 
 	case "leave_conversation":
 		if c.UserID == 0 {
@@ -217,42 +514,230 @@ func (c *Client) ProcessMessage(msg models.WSMessage) {
 		var payload struct {
 			ConversationID int `json:"conversation_id"`
 		}
-		json.Unmarshal(msg.Payload, &payload)
-		c.Hub.Store.LeaveConversation(c.UserID, payload.ConversationID)
-		// Refresh
-		convs, _ := c.Hub.Store.GetUserConversations(c.UserID)
-		c.SendJSON(map[string]interface{}{
-			"type": "conversations", "conversations": convs,
-		})
+		if !c.decode(msg.Payload, &payload) {
+			return
+		}
+		if err := c.Hub.Store.LeaveConversation(c.UserID, payload.ConversationID); err != nil {
+			c.log.Error("Failed to leave conversation", "conversation_id", payload.ConversationID, "error", err)
+			c.SendError("error", "Failed to leave conversation")
+			return
+		}
+		c.announceLeft(payload.ConversationID)
+		c.pushConversationRemoved(payload.ConversationID, c.UserID)
+		c.pushConversationUpdated(payload.ConversationID)
+
+	case "conversation_settings":
+		if c.UserID == 0 {
+			return
+		}
+		var payload models.ConversationSettingsPayload
+		if !c.decode(msg.Payload, &payload) {
+			return
+		}
+		c.handleSettings(payload)
+
+	default:
+		action = "unknown"
+		c.log.Warn("Unknown action")
 	}
 }
 
-func (c *Client) handleAuth(payload models.AuthPayload) (int, string, error) {
+// broadcastMessage delivers a new message to the conversation's
+// participants and alerts those it mentions.
+func (c *Client) broadcastMessage(msg *models.Message) {
+	// Only participants, so acks come from devices that should have it
+	ids, err := c.Hub.Store.GetParticipantIDs(msg.ConversationID)
+	if err != nil {
+		c.log.Error("Failed to load participants", "conversation_id", msg.ConversationID, "error", err)
+		return
+	}
+	c.Hub.SendToUsers(ids, c.marshal(map[string]interface{}{
+		"type":    "new_message",
+		"message": msg,
+	}))
+	c.notifyMentioned(msg)
+}
+
+// decode unmarshals an action payload, logging malformed input.
+func (c *Client) decode(payload json.RawMessage, v interface{}) bool {
+	if err := json.Unmarshal(payload, v); err != nil {
+		c.log.Warn("Invalid payload", "error", err)
+		return false
+	}
+	return true
+}
+
+func (c *Client) sendConversations() {
+	convs, err := c.Hub.Store.GetUserConversations(c.UserID)
+	if err != nil {
+		c.log.Error("Failed to load conversations", "error", err)
+	}
+	c.SendJSON(map[string]interface{}{
+		"type":          "conversations",
+		"conversations": convs,
+	})
+}
+
+// sendConversationInfo replies with a conversation's details: metadata,
+// message count and participants with their roles and presence.
+func (c *Client) sendConversationInfo(convID int) {
+	conv, count, err := c.Hub.Store.GetConversation(convID)
+	if err != nil {
+		c.log.Error("Failed to load conversation", "conversation_id", convID, "error", err)
+		c.SendError("error", "Failed to load conversation")
+		return
+	}
+	participants, err := c.Hub.Store.GetParticipants(convID)
+	if err != nil {
+		c.log.Error("Failed to load participants", "conversation_id", convID, "error", err)
+		c.SendError("error", "Failed to load conversation")
+		return
+	}
+
+	online := c.Hub.OnlineUsers()
+	for i := range participants {
+		participants[i].Online = online[participants[i].UserID]
+	}
+	conv.Participants = participants
+	conv.MessageCount = count
+
+	c.SendJSON(map[string]interface{}{
+		"type":         "conversation_info",
+		"conversation": conv,
+	})
+}
+
+func (c *Client) handleAuth(payload models.AuthPayload) (*models.User, error) {
 	if payload.Action == "register" {
-		hash, err := bcrypt.GenerateFromPassword([]byte(payload.Password), bcrypt.DefaultCost)
+		hash, err := c.Hub.Hasher.Hash(payload.Password)
+		if err != nil {
+			c.log.Error("Failed to hash password", "error", err)
+			return nil, errAuthFailed
+		}
+		id, err := c.Hub.Store.CreateUser(payload.Username, hash)
+		if errors.Is(err, storage.ErrUsernameTaken) {
+			return nil, err
+		}
 		if err != nil {
-			return 0, "", err
+			c.log.Error("Failed to create user", "error", err)
+			return nil, errAuthFailed
 		}
-		id, err := c.Hub.Store.CreateUser(payload.Username, string(hash))
-		return id, payload.Username, err
+		return &models.User{ID: id, Username: payload.Username}, nil
 	}
 
 	// Login
+	// Unknown users cost a hash too, so timing doesn't reveal who exists
 	user, err := c.Hub.Store.GetUserByUsername(payload.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.Hub.Hasher.VerifyDummy(payload.Password)
+		return nil, errInvalidCredentials
+	}
+	if err != nil {
+		c.log.Error("Failed to load user", "error", err)
+		return nil, errAuthFailed
+	}
+
+	ok, err := c.Hub.Hasher.Verify(payload.Password, user.PasswordHash)
 	if err != nil {
-		return 0, "", err
+		c.log.Error("Failed to verify password", "user_id", user.ID, "error", err)
+		return nil, errAuthFailed
+	}
+	if !ok {
+		return nil, errInvalidCredentials
+	}
+	if user.Disabled {
+		return nil, errAccountDisabled
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(payload.Password)); err != nil {
-		return 0, "", err
+	// Transparently upgrade bcrypt or outdated argon2id hashes
+	if c.Hub.Hasher.NeedsRehash(user.PasswordHash) {
+		if hash, err := c.Hub.Hasher.Hash(payload.Password); err != nil {
+			c.log.Error("Failed to rehash password", "user_id", user.ID, "error", err)
+		} else if err := c.Hub.Store.UpdatePasswordHash(user.ID, hash); err != nil {
+			c.log.Error("Failed to store rehashed password", "user_id", user.ID, "error", err)
+		} else {
+			user.PasswordHash = hash
+		}
 	}
 
-	return user.ID, user.Username, nil
+	return user, nil
+}
+
+func (c *Client) completeAuth(user *models.User) {
+	c.UserID = user.ID
+	c.Username = user.Username
+	c.Logger = c.Logger.With("user_id", user.ID, "username", user.Username)
+	c.log = c.log.With("user_id", user.ID, "username", user.Username)
+	c.Hub.Register <- c
+	c.log.Info("Authenticated")
+
+	convs, err := c.Hub.Store.GetUserConversations(user.ID)
+	if err != nil {
+		c.log.Error("Failed to load conversations", "error", err)
+	}
+	c.SendJSON(map[string]interface{}{
+		"type":          "auth_success",
+		"user_id":       user.ID,
+		"username":      user.Username,
+		"totp_enabled":  user.TOTPEnabled,
+		"conversations": convs,
+	})
 }
 
 func (c *Client) SendJSON(v interface{}) {
-	data, _ := json.Marshal(v)
-	c.Send <- data
+	data := c.marshal(v)
+	if data == nil {
+		return
+	}
+
+	// The hub may have closed Send (forced logout, slow client) while this
+	// connection was still processing a request
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if c.sendClosed {
+		return
+	}
+	select {
+	case c.Send <- data:
+	default:
+		c.Logger.Warn("Send buffer full, dropping frame")
+	}
+}
+
+// echoMessage answers a resend of a message that was already stored: only
+// the sending device still needs to see it.
+func (c *Client) echoMessage(msg *models.Message) {
+	c.SendJSON(map[string]interface{}{
+		"type":    "new_message",
+		"message": msg,
+	})
+}
+
+func (c *Client) sendAuthError(code, msg string) {
+	c.SendJSON(map[string]string{
+		"type":  "auth_error",
+		"code":  code,
+		"error": msg,
+	})
+}
+
+func authErrorCode(err error) string {
+	switch {
+	case errors.Is(err, errInvalidCredentials):
+		return authInvalidCredentials
+	case errors.Is(err, errAccountDisabled):
+		return authAccountDisabled
+	case errors.Is(err, storage.ErrUsernameTaken):
+		return authUsernameTaken
+	}
+	return authFailed
+}
+
+// isUserError reports whether a store error is about the request rather
+// than the database, so its message can go back to the client.
+func isUserError(err error) bool {
+	var notFound *storage.UserNotFoundError
+	return errors.As(err, &notFound) || errors.Is(err, storage.ErrDMNeedsOneUser)
 }
 
 func (c *Client) SendError(typeStr, errStr string) {
@@ -262,7 +747,12 @@ func (c *Client) SendError(typeStr, errStr string) {
 	})
 }
 
-func (c *Client) MarshalJSON(v interface{}) []byte {
-	data, _ := json.Marshal(v)
+// marshal encodes an outgoing frame, returning nil (and logging) on failure.
+func (c *Client) marshal(v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		c.Logger.Error("Failed to encode frame", "error", err)
+		return nil
+	}
 	return data
 }
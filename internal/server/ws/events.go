@@ -0,0 +1,102 @@
+package ws
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/cloudzz-dev/cldzmsg/internal/server/metrics"
+	"github.com/cloudzz-dev/cldzmsg/internal/server/models"
+)
+
+// postSystemMessage records an event in the conversation timeline and sends
+// it to every participant, plus extra users who just left and should still
+// see why.
+func (c *Client) postSystemMessage(convID int, kind, content string, extra ...int) {
+	msg, err := c.Hub.Store.SaveSystemMessage(convID, c.UserID, kind, content)
+	if err != nil {
+		c.log.Error("Failed to save system message", "conversation_id", convID, "kind", kind, "error", err)
+		return
+	}
+	metrics.MessagesSent.Inc()
+
+	ids, err := c.Hub.Store.GetParticipantIDs(convID)
+	if err != nil {
+		c.log.Error("Failed to load participants", "conversation_id", convID, "error", err)
+		return
+	}
+	c.Hub.SendToUsers(append(ids, extra...), c.marshal(map[string]interface{}{
+		"type":    "new_message",
+		"message": msg,
+	}))
+}
+
+func renameEvent(actor, name string) string {
+	if name == "" {
+		return fmt.Sprintf("%s removed the conversation name", actor)
+	}
+	return fmt.Sprintf("%s renamed the conversation to %q", actor, name)
+}
+
+func (c *Client) announceAdded(convID int, username string) {
+	c.postSystemMessage(convID, models.MessageMemberAdded, fmt.Sprintf("%s added %s", c.Username, username))
+}
+
+func (c *Client) announceRemoved(convID, userID int, username string) {
+	c.postSystemMessage(convID, models.MessageMemberRemoved, fmt.Sprintf("%s removed %s", c.Username, username), userID)
+}
+
+func (c *Client) announceLeft(convID int) {
+	c.postSystemMessage(convID, models.MessageMemberLeft, fmt.Sprintf("%s left", c.Username), c.UserID)
+}
+
+func (c *Client) announceRenamed(convID int, name string) {
+	c.postSystemMessage(convID, models.MessageRenamed, renameEvent(c.Username, name))
+}
+
+// Conversation list events, pushed to every connection of the affected
+// users so sidebars stay in sync across users and devices.
+const (
+	conversationAdded   = "conversation_added"
+	conversationUpdated = "conversation_updated"
+	conversationRemoved = "conversation_removed"
+)
+
+// pushConversation sends each user their own view of the conversation,
+// since DM names and unread counts differ per user.
+func (c *Client) pushConversation(event string, convID int, userIDs ...int) {
+	c.pushConversationExcept(nil, event, convID, userIDs...)
+}
+
+// pushConversationExcept is pushConversation without the skip connection.
+func (c *Client) pushConversationExcept(skip *Client, event string, convID int, userIDs ...int) {
+	for _, id := range userIDs {
+		conv, err := c.Hub.Store.GetUserConversation(id, convID)
+		if err != nil {
+			c.log.Error("Failed to load conversation for push", "conversation_id", convID, "user_id", id, "error", err)
+			continue
+		}
+		c.Hub.SendToUsersExcept([]int{id}, c.marshal(map[string]interface{}{
+			"type":         event,
+			"conversation": conv,
+		}), skip)
+	}
+}
+
+func (c *Client) pushConversationRemoved(convID int, userIDs ...int) {
+	c.Hub.SendToUsers(userIDs, c.marshal(map[string]interface{}{
+		"type":            conversationRemoved,
+		"conversation_id": convID,
+	}))
+}
+
+// pushConversationUpdated notifies current participants, except the given
+// users who were sent a more specific event.
+func (c *Client) pushConversationUpdated(convID int, except ...int) {
+	ids, err := c.Hub.Store.GetParticipantIDs(convID)
+	if err != nil {
+		c.log.Error("Failed to load participants", "conversation_id", convID, "error", err)
+		return
+	}
+	ids = slices.DeleteFunc(ids, func(id int) bool { return slices.Contains(except, id) })
+	c.pushConversation(conversationUpdated, convID, ids...)
+}
@@ -1,55 +1,184 @@
 package ws
 
 import (
+	"context"
+	"encoding/json"
 	"sync"
 
+	"github.com/cloudzz-dev/cldzmsg/internal/server/attachments"
+	"github.com/cloudzz-dev/cldzmsg/internal/server/auth"
+	"github.com/cloudzz-dev/cldzmsg/internal/server/metrics"
 	"github.com/cloudzz-dev/cldzmsg/internal/server/storage"
 )
 
 type Hub struct {
 	Clients    map[*Client]bool
-	Broadcast  chan []byte
+	Deliver    chan Delivery
 	Register   chan *Client
 	Unregister chan *Client
 	Store      *storage.Store
+	Files      *attachments.Store // Shared files; nil disables attachments
+	TOTP       *auth.TOTP
+	Hasher     *auth.PasswordHasher
 	mu         sync.RWMutex
+
+	// ping lets readiness checks confirm Run is still servicing channels
+	ping chan chan struct{}
+	kick chan kickRequest
+}
+
+// Delivery is a frame for every connection of the listed users, except
+// Skip if set.
+type Delivery struct {
+	UserIDs []int
+	Data    []byte
+	Skip    *Client
+}
+
+type kickRequest struct {
+	userID int
+	reason string
+	done   chan int
 }
 
-func NewHub(store *storage.Store) *Hub {
+func NewHub(store *storage.Store, hasher *auth.PasswordHasher) *Hub {
 	return &Hub{
-		Broadcast:  make(chan []byte),
+		Deliver:    make(chan Delivery),
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
 		Clients:    make(map[*Client]bool),
+		ping:       make(chan chan struct{}),
+		kick:       make(chan kickRequest),
 		Store:      store,
+		TOTP:       auth.NewTOTP(),
+		Hasher:     hasher,
 	}
 }
 
 func (h *Hub) Run() {
 	for {
 		select {
+		case reply := <-h.ping:
+			close(reply)
+		case req := <-h.kick:
+			req.done <- h.disconnectUser(req.userID, req.reason)
 		case client := <-h.Register:
 			h.mu.Lock()
 			h.Clients[client] = true
 			h.mu.Unlock()
 		case client := <-h.Unregister:
 			h.mu.Lock()
-			if _, ok := h.Clients[client]; ok {
-				delete(h.Clients, client)
-				close(client.Send)
-			}
+			delete(h.Clients, client)
 			h.mu.Unlock()
-		case message := <-h.Broadcast:
-			h.mu.RLock()
+			// Also for clients that never authenticated, so WritePump exits
+			client.closeSend()
+		case d := <-h.Deliver:
+			users := make(map[int]bool, len(d.UserIDs))
+			for _, id := range d.UserIDs {
+				users[id] = true
+			}
+			h.mu.Lock()
 			for client := range h.Clients {
-				select {
-				case client.Send <- message:
-				default:
-					close(client.Send)
-					delete(h.Clients, client)
+				if users[client.UserID] && client != d.Skip {
+					h.send(client, d.Data)
 				}
 			}
-			h.mu.RUnlock()
+			h.mu.Unlock()
+		}
+	}
+}
+
+// send queues a frame, dropping the client if its buffer is full. The
+// caller holds the write lock.
+func (h *Hub) send(client *Client, message []byte) {
+	select {
+	case client.Send <- message:
+	default:
+		metrics.SendDrops.Inc()
+		client.Logger.Warn("Dropping slow client, send buffer full")
+		client.closeSend()
+		delete(h.Clients, client)
+	}
+}
+
+// SendToUsers delivers a frame to every connection of the given users.
+func (h *Hub) SendToUsers(userIDs []int, data []byte) {
+	h.SendToUsersExcept(userIDs, data, nil)
+}
+
+// SendToUsersExcept is SendToUsers without the skip connection, for
+// changes it has already been told about.
+func (h *Hub) SendToUsersExcept(userIDs []int, data []byte, skip *Client) {
+	if len(userIDs) == 0 || data == nil {
+		return
+	}
+	h.Deliver <- Delivery{UserIDs: userIDs, Data: data, Skip: skip}
+}
+
+// Disconnect force-logs out every connection of a user and returns how
+// many were closed. Clients get a force_logout frame before the socket closes.
+func (h *Hub) Disconnect(userID int, reason string) int {
+	req := kickRequest{userID: userID, reason: reason, done: make(chan int, 1)}
+	h.kick <- req
+	return <-req.done
+}
+
+func (h *Hub) disconnectUser(userID int, reason string) int {
+	frame, _ := json.Marshal(map[string]string{"type": "force_logout", "reason": reason})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := 0
+	for client := range h.Clients {
+		if client.UserID != userID {
+			continue
+		}
+		// WritePump flushes the frame, then exits and closes the socket
+		select {
+		case client.Send <- frame:
+		default:
 		}
+		client.closeSend()
+		delete(h.Clients, client)
+		n++
+	}
+	return n
+}
+
+// OnlineUsers returns the IDs of users with at least one connection.
+func (h *Hub) OnlineUsers() map[int]bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	online := make(map[int]bool)
+	for client := range h.Clients {
+		online[client.UserID] = true
+	}
+	return online
+}
+
+// Ping round-trips through the Run loop and fails if it is stuck.
+func (h *Hub) Ping(ctx context.Context) error {
+	reply := make(chan struct{})
+	select {
+	case h.ping <- reply:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-reply:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// AuthenticatedUsers counts distinct users with a registered connection.
+func (h *Hub) AuthenticatedUsers() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	users := make(map[int]struct{})
+	for client := range h.Clients {
+		users[client.UserID] = struct{}{}
 	}
+	return len(users)
 }
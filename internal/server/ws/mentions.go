@@ -0,0 +1,88 @@
+package ws
+
+import (
+	"strings"
+
+	"github.com/cloudzz-dev/cldzmsg/internal/server/models"
+)
+
+// parseMentions returns the names written as @name in content, without
+// trailing punctuation, each once. An @ inside a word ("a@b.c") is not a
+// mention.
+func parseMentions(content string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, word := range strings.Fields(content) {
+		if !strings.HasPrefix(word, "@") {
+			continue
+		}
+		name := strings.TrimRight(word[1:], ".,;:!?)'\"")
+		if name == "" || seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// matchMentions resolves mentioned names to participants other than the
+// sender. Names of non-participants are ignored.
+func matchMentions(names []string, participants []models.Participant, senderID int) []int {
+	var ids []int
+	for _, p := range participants {
+		if p.UserID == senderID {
+			continue
+		}
+		for _, name := range names {
+			if strings.EqualFold(name, p.Username) {
+				ids = append(ids, p.UserID)
+				break
+			}
+		}
+	}
+	return ids
+}
+
+// recordMentions stores who msg mentions and sets msg.Mentions.
+func (c *Client) recordMentions(msg *models.Message) {
+	names := parseMentions(msg.Content)
+	if len(names) == 0 {
+		return
+	}
+	participants, err := c.Hub.Store.GetParticipants(msg.ConversationID)
+	if err != nil {
+		c.log.Error("Failed to load participants", "conversation_id", msg.ConversationID, "error", err)
+		return
+	}
+	ids := matchMentions(names, participants, msg.SenderID)
+	if len(ids) == 0 {
+		return
+	}
+	if err := c.Hub.Store.SaveMentions(msg.ID, ids); err != nil {
+		c.log.Error("Failed to save mentions", "message_id", msg.ID, "error", err)
+		return
+	}
+	msg.Mentions = ids
+}
+
+// notifyMentioned sends a mention event to everyone msg mentions. Clients
+// alert on it even when the conversation is muted.
+func (c *Client) notifyMentioned(msg *models.Message) {
+	c.Hub.SendToUsers(msg.Mentions, c.marshal(map[string]interface{}{
+		"type":            "mention",
+		"conversation_id": msg.ConversationID,
+		"message":         msg,
+	}))
+}
+
+func (c *Client) attachMentions(convID, since int, msgs []models.Message) {
+	mentions, err := c.Hub.Store.GetMentions(convID, since)
+	if err != nil {
+		c.log.Error("Failed to load mentions", "conversation_id", convID, "error", err)
+		return
+	}
+	for i := range msgs {
+		msgs[i].Mentions = mentions[msgs[i].ID]
+	}
+}
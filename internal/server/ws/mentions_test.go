@@ -0,0 +1,39 @@
+package ws
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/cloudzz-dev/cldzmsg/internal/server/models"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []string
+	}{
+		{"hello", nil},
+		{"@alice hi", []string{"alice"}},
+		{"hi @alice, @bob!", []string{"alice", "bob"}},
+		{"@alice @Alice @alice.", []string{"alice"}},
+		{"mail me at me@example.com", nil},
+		{"just @ nothing", nil},
+	}
+	for _, tt := range tests {
+		if got := parseMentions(tt.content); !slices.Equal(got, tt.want) {
+			t.Errorf("parseMentions(%q) = %q, want %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestMatchMentions(t *testing.T) {
+	participants := []models.Participant{
+		{UserID: 1, Username: "alice"},
+		{UserID: 2, Username: "Bob"},
+		{UserID: 3, Username: "carol"},
+	}
+	got := matchMentions([]string{"alice", "bob", "dave"}, participants, 1)
+	if !slices.Equal(got, []int{2}) {
+		t.Errorf("matchMentions = %v, want [2] (sender and non-members skipped)", got)
+	}
+}
@@ -0,0 +1,66 @@
+package ws
+
+import "github.com/cloudzz-dev/cldzmsg/internal/server/models"
+
+// markRead advances the caller's read position and, if it moved, tells
+// every participant (including the caller's other devices) which message
+// they have read up to.
+func (c *Client) markRead(convID, upTo int) {
+	lastRead, advanced, err := c.Hub.Store.UpdateReadReceipt(c.UserID, convID, upTo)
+	if err != nil {
+		c.log.Error("Failed to update read receipt", "conversation_id", convID, "error", err)
+		return
+	}
+	if !advanced {
+		return
+	}
+
+	ids, err := c.Hub.Store.GetParticipantIDs(convID)
+	if err != nil {
+		c.log.Error("Failed to load participants", "conversation_id", convID, "error", err)
+		return
+	}
+	c.Hub.SendToUsers(ids, c.marshal(map[string]interface{}{
+		"type":            "read_receipt",
+		"conversation_id": convID,
+		"user_id":         c.UserID,
+		"username":        c.Username,
+		"message_id":      lastRead,
+	}))
+}
+
+// notifyDelivered tells each sender which of their messages just reached
+// the caller's device, one frame per sender and conversation.
+func (c *Client) notifyDelivered(ds []models.Delivery) {
+	type key struct{ sender, conv int }
+	batches := make(map[key][]int)
+	for _, d := range ds {
+		if d.SenderID == 0 {
+			continue // Sender's account was deleted
+		}
+		k := key{d.SenderID, d.ConversationID}
+		batches[k] = append(batches[k], d.MessageID)
+	}
+	for k, ids := range batches {
+		c.Hub.SendToUsers([]int{k.sender}, c.marshal(map[string]interface{}{
+			"type":            "message_delivered",
+			"conversation_id": k.conv,
+			"user_id":         c.UserID,
+			"message_ids":     ids,
+		}))
+	}
+}
+
+// attachDeliveries fills DeliveredTo on the caller's own messages.
+func (c *Client) attachDeliveries(convID, since int, msgs []models.Message) {
+	deliveries, err := c.Hub.Store.GetDeliveries(convID, c.UserID, since)
+	if err != nil {
+		c.log.Error("Failed to load deliveries", "conversation_id", convID, "error", err)
+		return
+	}
+	for i := range msgs {
+		if msgs[i].SenderID == c.UserID {
+			msgs[i].DeliveredTo = deliveries[msgs[i].ID]
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package ws
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/cloudzz-dev/cldzmsg/internal/server/models"
+)
+
+var (
+	errNotMember    = errors.New("you are not in this conversation")
+	errNotGroup     = errors.New("only group conversations have roles")
+	errNotPermitted = errors.New("you don't have permission to do that")
+	errNoSuchMember = errors.New("that user is not in this conversation")
+	errIsMember     = errors.New("that user is already in this conversation")
+)
+
+// canManage reports whether a role may rename the group and add people.
+func canManage(role string) bool {
+	return role == models.RoleOwner || role == models.RoleAdmin
+}
+
+// canRemove reports whether actor may remove target. The owner can remove
+// anyone else; admins can only remove plain members.
+func canRemove(actor, target string) bool {
+	switch actor {
+	case models.RoleOwner:
+		return target != models.RoleOwner
+	case models.RoleAdmin:
+		return target == models.RoleMember
+	}
+	return false
+}
+
+// checkRoleChange validates promote, demote and transfer_ownership, which
+// only the owner may do.
+func checkRoleChange(action, actor, target string) error {
+	if actor != models.RoleOwner {
+		return errNotPermitted
+	}
+	switch action {
+	case "promote":
+		if target != models.RoleMember {
+			return errors.New("only members can be promoted")
+		}
+	case "demote":
+		if target != models.RoleAdmin {
+			return errors.New("only admins can be demoted")
+		}
+	case "transfer_ownership":
+		if target == models.RoleOwner {
+			return errors.New("you already own this group")
+		}
+	}
+	return nil
+}
+
+// membership loads the caller's role, replying with an error if the
+// caller is not a participant.
+func (c *Client) membership(convID int) (role string, isGroup bool, ok bool) {
+	role, isGroup, err := c.Hub.Store.GetMembership(convID, c.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.SendError("error", errNotMember.Error())
+		return "", false, false
+	}
+	if err != nil {
+		c.log.Error("Failed to load membership", "conversation_id", convID, "error", err)
+		c.SendError("error", "Failed to load conversation")
+		return "", false, false
+	}
+	return role, isGroup, true
+}
+
+// target resolves a username to a participant of the conversation.
+func (c *Client) target(convID int, username string) (userID int, role string, ok bool) {
+	exists, userID := c.Hub.Store.CheckUserExists(username)
+	if !exists {
+		c.SendError("error", errNoSuchMember.Error())
+		return 0, "", false
+	}
+	role, _, err := c.Hub.Store.GetMembership(convID, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.SendError("error", errNoSuchMember.Error())
+		return 0, "", false
+	}
+	if err != nil {
+		c.log.Error("Failed to load membership", "conversation_id", convID, "user_id", userID, "error", err)
+		c.SendError("error", "Failed to load conversation")
+		return 0, "", false
+	}
+	return userID, role, true
+}
+
+func (c *Client) handleAddParticipant(p models.ParticipantPayload) {
+	role, isGroup, ok := c.membership(p.ConversationID)
+	if !ok {
+		return
+	}
+	if !isGroup {
+		c.SendError("error", errNotGroup.Error())
+		return
+	}
+	if !canManage(role) {
+		c.SendError("error", errNotPermitted.Error())
+		return
+	}
+
+	added, err := c.Hub.Store.AddParticipant(p.ConversationID, p.Username)
+	if isUserError(err) {
+		c.SendError("error", err.Error())
+		return
+	}
+	if err != nil {
+		c.log.Error("Failed to add participant", "conversation_id", p.ConversationID, "username", p.Username, "error", err)
+		c.SendError("error", "Failed to add participant")
+		return
+	}
+	if !added {
+		c.SendError("error", errIsMember.Error())
+		return
+	}
+	c.announceAdded(p.ConversationID, p.Username)
+	c.sendConversationInfo(p.ConversationID)
+	if _, userID := c.Hub.Store.CheckUserExists(p.Username); userID != 0 {
+		c.pushConversation(conversationAdded, p.ConversationID, userID)
+		c.pushConversationUpdated(p.ConversationID, userID)
+	}
+}
+
+// handleRename lets any participant name a DM; groups need owner or admin.
+func (c *Client) handleRename(convID int, name string) {
+	role, isGroup, ok := c.membership(convID)
+	if !ok {
+		return
+	}
+	if isGroup && !canManage(role) {
+		c.SendError("error", errNotPermitted.Error())
+		return
+	}
+
+	if err := c.Hub.Store.RenameConversation(convID, name); err != nil {
+		c.log.Error("Failed to rename conversation", "conversation_id", convID, "error", err)
+		c.SendError("error", "Failed to rename conversation")
+		return
+	}
+	c.announceRenamed(convID, name)
+	c.sendConversationInfo(convID)
+	c.pushConversationUpdated(convID)
+}
+
+func (c *Client) handleRemoveParticipant(p models.ParticipantPayload) {
+	role, isGroup, ok := c.membership(p.ConversationID)
+	if !ok {
+		return
+	}
+	if !isGroup {
+		c.SendError("error", errNotGroup.Error())
+		return
+	}
+	userID, targetRole, ok := c.target(p.ConversationID, p.Username)
+	if !ok {
+		return
+	}
+	if userID == c.UserID || !canRemove(role, targetRole) {
+		c.SendError("error", errNotPermitted.Error())
+		return
+	}
+
+	if err := c.Hub.Store.RemoveParticipant(p.ConversationID, userID); err != nil {
+		c.log.Error("Failed to remove participant", "conversation_id", p.ConversationID, "user_id", userID, "error", err)
+		c.SendError("error", "Failed to remove participant")
+		return
+	}
+	c.log.Info("Removed participant", "conversation_id", p.ConversationID, "user_id", userID)
+	c.announceRemoved(p.ConversationID, userID, p.Username)
+	c.sendConversationInfo(p.ConversationID)
+	c.pushConversationRemoved(p.ConversationID, userID)
+	c.pushConversationUpdated(p.ConversationID)
+}
+
+// handleRoleChange implements promote, demote and transfer_ownership.
+func (c *Client) handleRoleChange(action string, p models.ParticipantPayload) {
+	role, isGroup, ok := c.membership(p.ConversationID)
+	if !ok {
+		return
+	}
+	if !isGroup {
+		c.SendError("error", errNotGroup.Error())
+		return
+	}
+	userID, targetRole, ok := c.target(p.ConversationID, p.Username)
+	if !ok {
+		return
+	}
+	if err := checkRoleChange(action, role, targetRole); err != nil {
+		c.SendError("error", err.Error())
+		return
+	}
+
+	var err error
+	switch action {
+	case "promote":
+		err = c.Hub.Store.SetParticipantRole(p.ConversationID, userID, models.RoleAdmin)
+	case "demote":
+		err = c.Hub.Store.SetParticipantRole(p.ConversationID, userID, models.RoleMember)
+	case "transfer_ownership":
+		err = c.Hub.Store.TransferOwnership(p.ConversationID, c.UserID, userID)
+	}
+	if err != nil {
+		c.log.Error("Failed to change role", "conversation_id", p.ConversationID, "user_id", userID, "error", err)
+		c.SendError("error", "Failed to change role")
+		return
+	}
+	c.log.Info("Changed role", "conversation_id", p.ConversationID, "user_id", userID)
+	c.sendConversationInfo(p.ConversationID)
+	c.pushConversationUpdated(p.ConversationID)
+}
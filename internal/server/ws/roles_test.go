@@ -0,0 +1,70 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/cloudzz-dev/cldzmsg/internal/server/models"
+	"github.com/cloudzz-dev/cldzmsg/internal/server/storage"
+)
+
+func TestCanRemove(t *testing.T) {
+	tests := []struct {
+		actor, target string
+		want          bool
+	}{
+		{models.RoleOwner, models.RoleAdmin, true},
+		{models.RoleOwner, models.RoleMember, true},
+		{models.RoleOwner, models.RoleOwner, false},
+		{models.RoleAdmin, models.RoleMember, true},
+		{models.RoleAdmin, models.RoleAdmin, false},
+		{models.RoleAdmin, models.RoleOwner, false},
+		{models.RoleMember, models.RoleMember, false},
+	}
+	for _, tt := range tests {
+		if got := canRemove(tt.actor, tt.target); got != tt.want {
+			t.Errorf("canRemove(%s, %s) = %v, want %v", tt.actor, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestCheckRoleChange(t *testing.T) {
+	tests := []struct {
+		action, actor, target string
+		ok                    bool
+	}{
+		{"promote", models.RoleOwner, models.RoleMember, true},
+		{"promote", models.RoleOwner, models.RoleAdmin, false},
+		{"promote", models.RoleAdmin, models.RoleMember, false},
+		{"demote", models.RoleOwner, models.RoleAdmin, true},
+		{"demote", models.RoleOwner, models.RoleMember, false},
+		{"transfer_ownership", models.RoleOwner, models.RoleMember, true},
+		{"transfer_ownership", models.RoleOwner, models.RoleAdmin, true},
+		{"transfer_ownership", models.RoleAdmin, models.RoleMember, false},
+	}
+	for _, tt := range tests {
+		err := checkRoleChange(tt.action, tt.actor, tt.target)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkRoleChange(%s, %s, %s) = %v, want ok=%v", tt.action, tt.actor, tt.target, err, tt.ok)
+		}
+	}
+}
+
+func TestIsUserError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{&storage.UserNotFoundError{Username: "bob"}, true},
+		{fmt.Errorf("add: %w", &storage.UserNotFoundError{Username: "bob"}), true},
+		{storage.ErrDMNeedsOneUser, true},
+		{errors.New(`pq: duplicate key value violates unique constraint "conversations_pkey"`), false},
+	}
+	for _, tt := range tests {
+		if got := isUserError(tt.err); got != tt.want {
+			t.Errorf("isUserError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package ws
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/cloudzz-dev/cldzmsg/internal/server/models"
+)
+
+// handleSettings updates the caller's own mute, archive and pin settings.
+// Only the caller's devices are told, as nobody else sees them.
+func (c *Client) handleSettings(p models.ConversationSettingsPayload) {
+	err := c.Hub.Store.UpdateConversationSettings(c.UserID, p)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.SendError("error", errNotMember.Error())
+		return
+	}
+	if err != nil {
+		c.log.Error("Failed to update conversation settings", "conversation_id", p.ConversationID, "error", err)
+		c.SendError("error", "Failed to update conversation settings")
+		return
+	}
+	c.pushConversation(conversationUpdated, p.ConversationID, c.UserID)
+}
@@ -0,0 +1,156 @@
+package ws
+
+import (
+	"github.com/cloudzz-dev/cldzmsg/internal/server/auth"
+	"github.com/cloudzz-dev/cldzmsg/internal/server/models"
+)
+
+const (
+	totpIssuer        = "cldzmsg"
+	recoveryCodeCount = 10
+)
+
+// verifySecondFactor accepts either a current TOTP code or an unused
+// recovery code. Both are single-use.
+func (c *Client) verifySecondFactor(user *models.User, code string) bool {
+	if auth.IsRecoveryCode(code) {
+		return c.useRecoveryCode(user.ID, auth.NormalizeRecoveryCode(code))
+	}
+
+	step, ok := c.Hub.TOTP.Verify(user.TOTPSecret, code, user.TOTPLastStep)
+	if !ok {
+		return false
+	}
+	advanced, err := c.Hub.Store.AdvanceTOTPStep(user.ID, step)
+	if err != nil {
+		c.log.Error("Failed to record TOTP step", "user_id", user.ID, "error", err)
+		return false
+	}
+	if !advanced {
+		return false
+	}
+	user.TOTPLastStep = step
+	return true
+}
+
+func (c *Client) useRecoveryCode(userID int, code string) bool {
+	codes, err := c.Hub.Store.GetUnusedRecoveryCodes(userID)
+	if err != nil {
+		c.log.Error("Failed to load recovery codes", "user_id", userID, "error", err)
+		return false
+	}
+	for _, rc := range codes {
+		if auth.MatchRecoveryCode(code, rc.CodeHash) {
+			used, err := c.Hub.Store.UseRecoveryCode(rc.ID)
+			if err != nil {
+				c.log.Error("Failed to consume recovery code", "user_id", userID, "error", err)
+				return false
+			}
+			if used {
+				c.log.Info("Recovery code used", "user_id", userID, "remaining", len(codes)-1)
+			}
+			return used
+		}
+	}
+	return false
+}
+
+// handleTOTPSetup starts enrollment with a fresh secret. 2FA is not enforced
+// until the user proves their authenticator works via totp_confirm.
+func (c *Client) handleTOTPSetup() {
+	_, enabled, _, err := c.Hub.Store.GetTOTP(c.UserID)
+	if err != nil {
+		c.log.Error("Failed to load 2FA settings", "error", err)
+		c.SendError("totp_error", "Could not load 2FA settings")
+		return
+	}
+	if enabled {
+		c.SendError("totp_error", "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := auth.GenerateSecret()
+	if err != nil {
+		c.log.Error("Failed to generate TOTP secret", "error", err)
+		c.SendError("totp_error", "Could not generate secret")
+		return
+	}
+	if err := c.Hub.Store.SetPendingTOTP(c.UserID, secret); err != nil {
+		c.log.Error("Failed to save TOTP secret", "error", err)
+		c.SendError("totp_error", "Could not save secret")
+		return
+	}
+
+	c.SendJSON(map[string]interface{}{
+		"type":   "totp_setup",
+		"secret": secret,
+		"uri":    c.Hub.TOTP.URI(totpIssuer, c.Username, secret),
+	})
+}
+
+func (c *Client) handleTOTPConfirm(code string) {
+	secret, enabled, lastStep, err := c.Hub.Store.GetTOTP(c.UserID)
+	if err != nil {
+		c.log.Error("Failed to load 2FA settings", "error", err)
+	}
+	if err != nil || secret == "" || enabled {
+		c.SendError("totp_error", "No two-factor enrollment in progress")
+		return
+	}
+
+	step, ok := c.Hub.TOTP.Verify(secret, code, lastStep)
+	if !ok {
+		c.SendError("totp_error", "Invalid verification code")
+		return
+	}
+
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		c.log.Error("Failed to generate recovery codes", "error", err)
+		c.SendError("totp_error", "Could not generate recovery codes")
+		return
+	}
+	hashes := make([]string, 0, len(codes))
+	for _, rc := range codes {
+		hashes = append(hashes, auth.HashRecoveryCode(rc))
+	}
+
+	if err := c.Hub.Store.EnableTOTP(c.UserID, step, hashes); err != nil {
+		c.log.Error("Failed to enable 2FA", "error", err)
+		c.SendError("totp_error", "Could not enable two-factor authentication")
+		return
+	}
+
+	c.log.Info("Two-factor authentication enabled")
+
+	// Recovery codes are only ever shown here
+	c.SendJSON(map[string]interface{}{
+		"type":           "totp_enabled",
+		"recovery_codes": codes,
+	})
+}
+
+func (c *Client) handleTOTPDisable(code string) {
+	secret, enabled, lastStep, err := c.Hub.Store.GetTOTP(c.UserID)
+	if err != nil {
+		c.log.Error("Failed to load 2FA settings", "error", err)
+	}
+	if err != nil || !enabled {
+		c.SendError("totp_error", "Two-factor authentication is not enabled")
+		return
+	}
+
+	user := &models.User{ID: c.UserID, TOTPSecret: secret, TOTPLastStep: lastStep}
+	if !c.verifySecondFactor(user, code) {
+		c.SendError("totp_error", "Invalid verification code")
+		return
+	}
+
+	if err := c.Hub.Store.DisableTOTP(c.UserID); err != nil {
+		c.log.Error("Failed to disable 2FA", "error", err)
+		c.SendError("totp_error", "Could not disable two-factor authentication")
+		return
+	}
+	c.log.Info("Two-factor authentication disabled")
+	c.SendJSON(map[string]interface{}{"type": "totp_disabled"})
+}